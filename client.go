@@ -5,12 +5,512 @@
 package bisoc
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"slices"
+	"time"
 )
 
-// TODO: Implement a client side api for the library
+// Dialer contains options for connecting to a WebSocket server.
+type Dialer struct {
+	// NetDialContext specifies the dial function for creating TCP connections.
+	// If nil, a zero value net.Dialer is used.
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Proxy returns the proxy for the opening handshake, it is called with a
+	// request whose url has the http or https scheme in place of ws or wss,
+	// so e.g. http.ProxyFromEnvironment honors HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY. The http and https proxy schemes open a tunnel with the
+	// CONNECT method, the socks5 and socks5h schemes with a SOCKS5 proxy,
+	// which resolves the host name only for socks5h. The user information of
+	// the proxy url is sent as credentials. If Proxy is nil or returns a nil
+	// url, no proxy is used.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// TLSClientConfig specifies the TLS configuration used for wss:// urls,
+	// e.g. the root CAs, client certificates or the server name sent with
+	// SNI, which defaults to the host of the url. The ALPN protocol is always
+	// http/1.1. If nil, the default configuration is used.
+	TLSClientConfig *tls.Config
+
+	// HandShakeTimeout is the duration for the handshake to complete.
+	HandShakeTimeout time.Duration
+
+	// Subprotocols specifies the client's requested protocols in order of
+	// preference.
+	Subprotocols []string
+
+	// Compression offers the permessage-deflate extension to the server.
+	// If nil, compression is not offered.
+	Compression *PerMessageDeflate
+
+	// Extensions specifies the extensions offered to the server in addition
+	// to Compression.
+	Extensions []Extension
+
+	// PingInterval enables keepalive pings, the peer is pinged when nothing
+	// was received from it for PingInterval. If zero, no pings are sent.
+	// The pongs are only received while the connection is read, so a
+	// goroutine must keep reading from it, e.g. with NextReader, or the
+	// connection is closed once PongTimeout elapses.
+	PingInterval time.Duration
+
+	// PongTimeout is the duration to wait for the pong of a keepalive ping,
+	// the connection is closed with [StatusGoingAway] if it does not arrive
+	// in time. If zero, PingInterval is used.
+	PongTimeout time.Duration
+
+	// HTTP2Transport enables WebSockets over HTTP/2, the opening handshake is
+	// an extended CONNECT request sent with HTTP2Transport, as described in
+	// RFC 8441, and the connection is carried by the request and response
+	// streams. It must send the request over HTTP/2 with the :protocol
+	// pseudo-header field, which the [http.Transport] of net/http rejects
+	// with an invalid header field name error. The Transport of
+	// golang.org/x/net/http2 sends it, but with Go 1.27 or later only when
+	// built with the http2legacy build tag, otherwise it wraps net/http.
+	// Proxy, NetDialContext and TLSClientConfig are not used then, the
+	// transport dials the server.
+	HTTP2Transport http.RoundTripper
+}
+
+// DefaultDialer is a dialer with all fields set to their default values, it
+// uses the proxy configured by the environment.
+var DefaultDialer = &Dialer{
+	Proxy:            http.ProxyFromEnvironment,
+	HandShakeTimeout: 45 * time.Second,
+}
+
+// A time in the past, used to unblock pending I/O on a connection.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Dial creates a new client connection by calling [Dialer.DialContext]
+// with a background context.
+func (d *Dialer) Dial(urlStr string, header http.Header) (*Conn, *http.Response, error) {
+	return d.DialContext(context.Background(), urlStr, header)
+}
+
+// DialContext creates a new client connection. The url must use the ws or
+// wss scheme, and header can be used to send additional request headers
+// (Origin, Cookie, etc.) in the opening handshake.
+//
+// The context bounds the opening handshake only, once the connection is
+// returned, cancelling the context has no effect on it.
+//
+// If the server does not accept the handshake, the returned error is
+// accompanied by the server's response so that callers can inspect the
+// status code and headers. The response body is truncated.
+func (d *Dialer) DialContext(ctx context.Context, urlStr string, header http.Header) (*Conn, *http.Response, error) {
+	if d == nil {
+		d = &Dialer{}
+	}
+
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch u.Scheme {
+	case "ws", "wss":
+	default:
+		return nil, nil, errors.New("bisoc: malformed url scheme: " + u.Scheme)
+	}
+
+	if u.User != nil {
+		// RFC 6455 (Section 3)
+		//
+		// There is no way to carry user information in a WebSocket URI.
+		return nil, nil, errors.New("bisoc: user information is not allowed in the url")
+	}
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+
+	for k, vs := range header {
+		switch k = http.CanonicalHeaderKey(k); k {
+		case "Host":
+			if len(vs) > 0 {
+				req.Host = vs[0]
+			}
+		case "Upgrade",
+			"Connection",
+			"Sec-Websocket-Key",
+			"Sec-Websocket-Version",
+			"Sec-Websocket-Extensions":
+			return nil, nil, errors.New("bisoc: duplicate header not allowed: " + k)
+		default:
+			req.Header[k] = vs
+		}
+	}
+
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", joinHeader(d.Subprotocols))
+	}
+	extensions := supportedExtensions(d.Compression, d.Extensions)
+	if len(extensions) > 0 {
+		req.Header.Set("Sec-WebSocket-Extensions", offerExtensions(extensions))
+	}
+
+	if d.HandShakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.HandShakeTimeout)
+		defer cancel()
+	}
+
+	if d.HTTP2Transport != nil {
+		return d.dialStream(ctx, req, extensions)
+	}
+
+	challengeKey, err := generateChallengeKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", challengeKey)
+
+	netDial := d.NetDialContext
+	if netDial == nil {
+		netDial = (&net.Dialer{}).DialContext
+	}
+
+	addr := hostPort(u)
+	proxyURL, err := d.proxyURL(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dialAddr := addr
+	if proxyURL != nil {
+		dialAddr = proxyAddr(proxyURL)
+	}
+
+	netConn, err := netDial(ctx, "tcp", dialAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Cleanup! Close the network connection when returning an error.
+	defer func() {
+		if netConn != nil {
+			netConn.Close()
+		}
+	}()
+
+	// Bound the handshake by the context, both its deadline and cancellation.
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := netConn.SetDeadline(deadline); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rawConn := netConn
+	stop := context.AfterFunc(ctx, func() {
+		rawConn.SetDeadline(aLongTimeAgo)
+	})
+	defer stop()
+
+	if proxyURL != nil {
+		netConn, err = d.tunnel(ctx, netConn, proxyURL, addr)
+		if err != nil {
+			return nil, nil, ctxErr(ctx, err)
+		}
+	}
+
+	if u.Scheme == "wss" {
+		cfg := d.TLSClientConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		} else {
+			cfg = cfg.Clone()
+		}
+
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+
+		// The opening handshake is an HTTP/1.1 request, a server which
+		// supports ALPN must not select another protocol.
+		cfg.NextProtos = []string{"http/1.1"}
+
+		tlsConn := tls.Client(netConn, cfg)
+		netConn = tlsConn
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, nil, tlsHandshakeErr(ctx, cfg.ServerName, err)
+		}
+	}
+
+	if err := req.Write(netConn); err != nil {
+		return nil, nil, ctxErr(ctx, err)
+	}
+
+	br := bufio.NewReaderSize(netConn, ReadBufSize)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, nil, ctxErr(ctx, err)
+	}
+
+	err = checkHandshakeResponse(resp, req, challengeKey)
+	var transforms []ExtensionTransform
+	if err == nil {
+		transforms, err = confirmExtensions(extensions, parseExtensions(resp.Header))
+		if err != nil {
+			err = errors.New(badServerHandShake + err.Error())
+		}
+	}
+
+	if err != nil {
+		// Keep a small part of the body for the caller, the connection
+		// is closed on return.
+		buf := make([]byte, 1024)
+		n, _ := io.ReadFull(resp.Body, buf)
+		resp.Body = io.NopCloser(bytes.NewReader(buf[:n]))
+		return nil, resp, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(nil))
+
+	if !stop() {
+		// the context was done while finishing the handshake.
+		return nil, resp, ctx.Err()
+	}
+
+	if err := netConn.SetDeadline(time.Time{}); err != nil {
+		return nil, resp, err
+	}
+
+	c := newConn(netConn, true, br, nil)
+	c.subprotocol = resp.Header.Get("Sec-Websocket-Protocol")
+	c.handshake = dialHandshakeInfo(req, resp, netConn.RemoteAddr().String())
+	if tlsConn, ok := netConn.(*tls.Conn); ok && u.Scheme == "wss" {
+		state := tlsConn.ConnectionState()
+		c.handshake.TLS = &state
+	}
+	c.setExtensions(transforms)
+	c.startKeepalive(d.PingInterval, d.PongTimeout)
+
+	// Success! This stops the above deferred cleanup function from closing the connection.
+	netConn = nil
+	return c, resp, nil
+}
+
+// checkHandshakeResponse validates the server's opening handshake as
+// described in RFC 6455 (Section 4.1).
+func checkHandshakeResponse(resp *http.Response, req *http.Request, challengeKey string) error {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return errors.New(badServerHandShake + "unexpected response status " + resp.Status)
+	}
+
+	if !headerContains(resp.Header["Upgrade"], "websocket") {
+		return errors.New(badServerHandShake + "'Upgrade' header of the response does not contains 'websocket'")
+	}
+
+	if !headerContains(resp.Header["Connection"], "upgrade") {
+		return errors.New(badServerHandShake + "'Connection' header of the response does not contains 'upgrade'")
+	}
+
+	if resp.Header.Get("Sec-Websocket-Accept") != computeAcceptKey([]byte(challengeKey)) {
+		return errors.New(badServerHandShake + "mismatched 'Sec-WebSocket-Accept' header")
+	}
+
+	return checkSubprotocol(resp, req)
+}
+
+// dialHandshakeInfo returns the handshake of a client connection.
+func dialHandshakeInfo(req *http.Request, resp *http.Response, remoteAddr string) HandshakeInfo {
+	hi := HandshakeInfo{
+		URL:            req.URL,
+		Host:           req.Host,
+		Header:         req.Header,
+		ResponseHeader: resp.Header,
+		Subprotocol:    resp.Header.Get("Sec-Websocket-Protocol"),
+		Extensions:     resp.Header.Get("Sec-Websocket-Extensions"),
+		RemoteAddr:     remoteAddr,
+	}.clone()
+
+	// the pseudo-header field of an extended CONNECT request is not a header.
+	delete(hi.Header, ":protocol")
+	return hi
+}
+
+// checkSubprotocol checks that the subprotocol selected by the server was
+// requested by the client.
+func checkSubprotocol(resp *http.Response, req *http.Request) error {
+	if p := resp.Header.Get("Sec-Websocket-Protocol"); p != "" {
+		if !slices.Contains(subProtocols(req.Header), p) {
+			return errors.New(badServerHandShake + "server selected a subprotocol not requested by the client")
+		}
+	}
+
+	return nil
+}
+
+// dialStream performs the opening handshake over HTTP/2 with an extended
+// CONNECT request, as described in RFC 8441 (Section 5).
+func (d *Dialer) dialStream(ctx context.Context, req *http.Request, extensions []Extension) (*Conn, *http.Response, error) {
+	wsURL := req.URL
+	u := *req.URL
+	u.Scheme = "http"
+	if req.URL.Scheme == "wss" {
+		u.Scheme = "https"
+	}
+
+	req.Method = http.MethodConnect
+	req.URL = &u
+	req.Proto = "HTTP/2.0"
+	req.ProtoMajor = 2
+	req.ProtoMinor = 0
+	req.Header.Set(":protocol", "websocket")
+
+	pr, pw := io.Pipe()
+	req.Body = pr
+
+	var local, remote net.Addr
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			local = info.Conn.LocalAddr()
+			remote = info.Conn.RemoteAddr()
+		},
+	}
+
+	// The stream outlives the context, which bounds the opening handshake only.
+	streamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, cancel)
+
+	resp, err := d.HTTP2Transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(streamCtx, trace)))
+	if err != nil {
+		cancel()
+		return nil, nil, ctxErr(ctx, err)
+	}
+
+	if !stop() {
+		// the context was done while finishing the handshake.
+		resp.Body.Close()
+		return nil, nil, ctx.Err()
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New(badServerHandShake + "unexpected response status " + resp.Status)
+	}
+
+	if err == nil {
+		err = checkSubprotocol(resp, req)
+	}
+
+	var transforms []ExtensionTransform
+	if err == nil {
+		transforms, err = confirmExtensions(extensions, parseExtensions(resp.Header))
+		if err != nil {
+			err = errors.New(badServerHandShake + err.Error())
+		}
+	}
+
+	if err != nil {
+		// Keep a small part of the body for the caller, the stream is
+		// closed on return.
+		buf := make([]byte, 1024)
+		n, _ := io.ReadFull(resp.Body, buf)
+		resp.Body.Close()
+		cancel()
+		resp.Body = io.NopCloser(bytes.NewReader(buf[:n]))
+		return nil, resp, err
+	}
+
+	body := resp.Body
+	resp.Body = io.NopCloser(bytes.NewReader(nil))
+
+	conn := newStreamConn(body, pw, func() {
+		pw.Close()
+		body.Close()
+		cancel()
+	})
+	if remote == nil {
+		// the transport did not report its connection.
+		local, remote = streamAddr(""), streamAddr(hostPort(wsURL))
+	}
+	conn.local = local
+	conn.remote = remote
+
+	c := newConn(conn, true, nil, nil)
+	c.subprotocol = resp.Header.Get("Sec-Websocket-Protocol")
+	req.URL = wsURL
+	c.handshake = dialHandshakeInfo(req, resp, remote.String())
+	c.handshake.TLS = resp.TLS
+	c.setExtensions(transforms)
+	c.startKeepalive(d.PingInterval, d.PongTimeout)
+
+	return c, resp, nil
+}
+
+// TLSHandshakeError is returned by [Dialer.DialContext] if the TLS handshake
+// with the server or an https proxy failed, e.g. as the certificate of the
+// server could not be verified. With TLS 1.3 the server verifies the client
+// certificate after the client finished the handshake, so its rejection is
+// reported by reading the server's response instead.
+type TLSHandshakeError struct {
+	ServerName string // name of the server used to verify its certificate
+	Err        error
+}
+
+func (e *TLSHandshakeError) Error() string {
+	return "bisoc: tls handshake with " + e.ServerName + " failed: " + e.Err.Error()
+}
+
+func (e *TLSHandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// tlsHandshakeErr returns the error of a failed TLS handshake, the context
+// error if the handshake was aborted by the context.
+func tlsHandshakeErr(ctx context.Context, serverName string, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return &TLSHandshakeError{ServerName: serverName, Err: err}
+}
+
+// ctxErr prefers the context error over err if the context is done,
+// as the I/O error is then just a side effect of the cancellation.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// hostPort returns the host and port of the url, using the default port
+// of the scheme if the url does not contain one.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
 
 var safeRandom = rand.Reader
 
@@ -0,0 +1,202 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newTLSTestServer starts an https server offering h2 and http/1.1 with ALPN
+// which echoes the messages of the accepted WebSocket connections.
+func newTLSTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&Server{}).Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		echo(c)
+	}))
+	s.EnableHTTP2 = true
+	s.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	s.StartTLS()
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestDialTLS(t *testing.T) {
+	s := newTLSTestServer(t)
+	u := "wss" + strings.TrimPrefix(s.URL, "https")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Certificate())
+
+	// h2 is never offered for a WebSocket connection over HTTP/1.1.
+	cfg := &tls.Config{RootCAs: roots, NextProtos: []string{"h2"}}
+	c, _, err := (&Dialer{TLSClientConfig: cfg}).Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	state, ok := c.TLSConnectionState()
+	if !ok || !state.HandshakeComplete {
+		t.Fatal("the TLS state of the connection is not exposed")
+	}
+
+	if state.NegotiatedProtocol != "http/1.1" {
+		t.Fatalf("negotiated protocol %q, want http/1.1", state.NegotiatedProtocol)
+	}
+
+	if len(state.PeerCertificates) == 0 || !state.PeerCertificates[0].Equal(s.Certificate()) {
+		t.Fatal("the peer certificates are not the certificates of the server")
+	}
+
+	if len(cfg.NextProtos) != 1 || cfg.NextProtos[0] != "h2" {
+		t.Fatalf("the TLS config of the dialer was modified: %q", cfg.NextProtos)
+	}
+
+	if err := c.SendMsg(TextMsg, "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, p, err := c.RecvMsg(); err != nil || string(p) != "hello" {
+		t.Fatalf("got %q, %v", p, err)
+	}
+}
+
+func TestDialTLSUnknownAuthority(t *testing.T) {
+	s := newTLSTestServer(t)
+	u := "wss" + strings.TrimPrefix(s.URL, "https")
+
+	_, _, err := (&Dialer{}).Dial(u, nil)
+
+	var te *TLSHandshakeError
+	if !errors.As(err, &te) {
+		t.Fatalf("got %v, want a *TLSHandshakeError", err)
+	}
+
+	if te.ServerName != "127.0.0.1" {
+		t.Fatalf("got server name %q, want 127.0.0.1", te.ServerName)
+	}
+
+	var ue x509.UnknownAuthorityError
+	if !errors.As(err, &ue) {
+		t.Fatalf("got %v, want an x509.UnknownAuthorityError", err)
+	}
+}
+
+func TestTLSConnectionStateThroughHTTPSProxy(t *testing.T) {
+	var tunnels atomic.Int32
+	proxy := httptest.NewTLSServer(connectHandler(nil, &tunnels))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	roots := x509.NewCertPool()
+	roots.AddCert(proxy.Certificate())
+
+	d := &Dialer{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}
+
+	u := newTestServer(t, &Server{}, echo)
+	c, _, err := d.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if tunnels.Load() != 1 {
+		t.Fatal("the connection was not tunneled through the proxy")
+	}
+
+	// the TLS session belongs to the proxy, not to the ws:// connection.
+	if _, ok := c.TLSConnectionState(); ok {
+		t.Fatal("a ws:// connection reports the TLS state of its proxy")
+	}
+}
+
+func TestHandshakeCopy(t *testing.T) {
+	// modify changes the memory which hi may share with the connection.
+	modify := func(hi HandshakeInfo) {
+		hi.URL.Path = "/modified"
+		hi.Header.Set("X-Test", "modified")
+		if hi.ResponseHeader != nil {
+			// the response header is only kept by the client.
+			hi.ResponseHeader.Set("X-Test", "modified")
+		}
+		hi.TLS.ServerName = "modified"
+		if len(hi.TLS.PeerCertificates) > 0 {
+			hi.TLS.PeerCertificates[0] = nil
+		}
+	}
+
+	// check reports whether the handshake of c is unchanged by modify.
+	check := func(c *Conn) error {
+		before := c.Handshake()
+		modify(c.Handshake())
+		after := c.Handshake()
+
+		switch {
+		case after.URL.String() != before.URL.String():
+			return errors.New("the url was modified")
+		case after.Header.Get("X-Test") != "original":
+			return errors.New("the request header was modified")
+		case after.ResponseHeader.Get("X-Test") != before.ResponseHeader.Get("X-Test"):
+			return errors.New("the response header was modified")
+		case after.TLS.ServerName != before.TLS.ServerName:
+			return errors.New("the TLS state was modified")
+		case len(after.TLS.PeerCertificates) != len(before.TLS.PeerCertificates) || slices.Contains(after.TLS.PeerCertificates, nil):
+			return errors.New("the peer certificates were modified")
+		}
+
+		return nil
+	}
+
+	checked := make(chan error, 1)
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&Server{}).Accept(w, r, http.Header{"X-Test": {"original"}})
+		if err != nil {
+			checked <- err
+			return
+		}
+		defer c.Close()
+
+		checked <- check(c)
+	}))
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Certificate())
+
+	d := &Dialer{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	c, _, err := d.Dial("wss"+strings.TrimPrefix(s.URL, "https")+"/chat", http.Header{"X-Test": {"original"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := check(c); err != nil {
+		t.Fatalf("client: %v", err)
+	}
+
+	if err := <-checked; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+}
@@ -0,0 +1,483 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PerMessageDeflate is the permessage-deflate [Extension] described in
+// RFC 7692, it is used by both [Server] and [Dialer].
+type PerMessageDeflate struct {
+	// ServerNoContextTakeover prevents the server from reusing the
+	// sliding window of previous messages when compressing a message.
+	ServerNoContextTakeover bool
+
+	// ClientNoContextTakeover prevents the client from reusing the
+	// sliding window of previous messages when compressing a message.
+	ClientNoContextTakeover bool
+
+	// ServerMaxWindowBits limits the LZ77 sliding window size used by the
+	// server to compress messages, it must be between 8 and 15. Zero
+	// means the default value 15.
+	ServerMaxWindowBits int
+
+	// ClientMaxWindowBits limits the LZ77 sliding window size used by the
+	// client to compress messages, it must be between 8 and 15. Zero
+	// means the default value 15.
+	ClientMaxWindowBits int
+
+	// Level is the flate compression level used for outgoing messages.
+	// Zero or an invalid level means [flate.DefaultCompression].
+	Level int
+}
+
+const (
+	deflateExtension = "permessage-deflate"
+
+	// Default and maximum LZ77 window size in bits.
+	maxWindowBits = 15
+
+	// Minimum LZ77 window size in bits, RFC 7692 (Section 7.1.2).
+	minWindowBits = 8
+
+	// Size of the sliding window kept for context takeover.
+	maxWindowSize = 1 << maxWindowBits
+
+	// deflateTail is appended to the payload of a compressed message before
+	// decompression, as it is removed by the sender, RFC 7692 (Section 7.2.2).
+	// It is followed by a final empty stored block, so that the flate reader
+	// reports the end of the message.
+	deflateTail = "\x00\x00\xff\xff\x01\x00\x00\xff\xff"
+)
+
+// deflateParams are the negotiated permessage-deflate parameters.
+type deflateParams struct {
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+	serverMaxWindowBits     int
+	clientMaxWindowBits     int
+}
+
+// parseDeflateParams parses the parameters of a permessage-deflate offer or
+// response, client_max_window_bits without a value is reported as -1.
+func parseDeflateParams(params []ExtensionParam) (deflateParams, error) {
+	dp := deflateParams{}
+	seen := make(map[string]bool, len(params))
+	for _, p := range params {
+		if seen[p.Name] {
+			return dp, errors.New("duplicate parameter " + p.Name)
+		}
+		seen[p.Name] = true
+
+		switch p.Name {
+		case "server_no_context_takeover":
+			if p.Value != "" {
+				return dp, errors.New("unexpected value for " + p.Name)
+			}
+			dp.serverNoContextTakeover = true
+		case "client_no_context_takeover":
+			if p.Value != "" {
+				return dp, errors.New("unexpected value for " + p.Name)
+			}
+			dp.clientNoContextTakeover = true
+		case "server_max_window_bits":
+			bits, err := parseWindowBits(p.Value)
+			if err != nil {
+				return dp, err
+			}
+			dp.serverMaxWindowBits = bits
+		case "client_max_window_bits":
+			// a client may offer this parameter without a value to signal
+			// that it supports it, RFC 7692 (Section 7.1.2.2).
+			if p.Value == "" {
+				dp.clientMaxWindowBits = -1
+				continue
+			}
+
+			bits, err := parseWindowBits(p.Value)
+			if err != nil {
+				return dp, err
+			}
+			dp.clientMaxWindowBits = bits
+		default:
+			return dp, errors.New("unknown parameter " + p.Name)
+		}
+	}
+
+	return dp, nil
+}
+
+func parseWindowBits(s string) (int, error) {
+	bits, err := strconv.Atoi(s)
+	if err != nil || bits < minWindowBits || bits > maxWindowBits || strconv.Itoa(bits) != s {
+		return 0, errors.New("invalid window bits " + strconv.Quote(s))
+	}
+
+	return bits, nil
+}
+
+func windowBits(bits int) int {
+	if bits <= 0 {
+		return maxWindowBits
+	}
+
+	return bits
+}
+
+func (pmd *PerMessageDeflate) level() int {
+	if pmd.Level == 0 || pmd.Level < flate.HuffmanOnly || pmd.Level > flate.BestCompression {
+		return flate.DefaultCompression
+	}
+
+	return pmd.Level
+}
+
+// Name returns "permessage-deflate".
+func (pmd *PerMessageDeflate) Name() string {
+	return deflateExtension
+}
+
+// Offer returns the client's permessage-deflate offer.
+func (pmd *PerMessageDeflate) Offer() []ExtensionParam {
+	var params []ExtensionParam
+	if pmd.ServerNoContextTakeover {
+		params = append(params, ExtensionParam{Name: "server_no_context_takeover"})
+	}
+	if pmd.ClientNoContextTakeover {
+		params = append(params, ExtensionParam{Name: "client_no_context_takeover"})
+	}
+	if bits := windowBits(pmd.ServerMaxWindowBits); bits < maxWindowBits {
+		params = append(params, ExtensionParam{Name: "server_max_window_bits", Value: strconv.Itoa(bits)})
+	}
+
+	p := ExtensionParam{Name: "client_max_window_bits"}
+	if bits := windowBits(pmd.ClientMaxWindowBits); bits < maxWindowBits {
+		p.Value = strconv.Itoa(bits)
+	}
+
+	return append(params, p)
+}
+
+// Accept returns the server's response to a permessage-deflate offer of the
+// client, invalid offers are declined, RFC 7692 (Section 5.1).
+func (pmd *PerMessageDeflate) Accept(offer []ExtensionParam) ([]ExtensionParam, ExtensionTransform, bool) {
+	dp, err := parseDeflateParams(offer)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	// The server must honour the limits requested by the client.
+	dp.serverNoContextTakeover = dp.serverNoContextTakeover || pmd.ServerNoContextTakeover
+	dp.serverMaxWindowBits = min(windowBits(dp.serverMaxWindowBits), windowBits(pmd.ServerMaxWindowBits))
+	dp.clientNoContextTakeover = pmd.ClientNoContextTakeover
+
+	// The window of the client can only be limited if it supports the
+	// client_max_window_bits parameter.
+	clientBits := maxWindowBits
+	if dp.clientMaxWindowBits != 0 {
+		clientBits = min(windowBits(dp.clientMaxWindowBits), windowBits(pmd.ClientMaxWindowBits))
+	}
+	dp.clientMaxWindowBits = clientBits
+
+	var params []ExtensionParam
+	if dp.serverNoContextTakeover {
+		params = append(params, ExtensionParam{Name: "server_no_context_takeover"})
+	}
+	if dp.clientNoContextTakeover {
+		params = append(params, ExtensionParam{Name: "client_no_context_takeover"})
+	}
+	if dp.serverMaxWindowBits < maxWindowBits {
+		params = append(params, ExtensionParam{Name: "server_max_window_bits", Value: strconv.Itoa(dp.serverMaxWindowBits)})
+	}
+	if dp.clientMaxWindowBits < maxWindowBits {
+		params = append(params, ExtensionParam{Name: "client_max_window_bits", Value: strconv.Itoa(dp.clientMaxWindowBits)})
+	}
+
+	return params, newDeflateConn(dp, false, pmd.level()), true
+}
+
+// Confirm validates the server's response to the client's permessage-deflate
+// offer, RFC 7692 (Section 5.1).
+func (pmd *PerMessageDeflate) Confirm(response []ExtensionParam) (ExtensionTransform, error) {
+	dp, err := parseDeflateParams(response)
+	if err != nil {
+		return nil, err
+	}
+
+	if dp.clientMaxWindowBits < 0 {
+		return nil, errors.New("missing value for client_max_window_bits")
+	}
+
+	if bits := windowBits(pmd.ServerMaxWindowBits); bits < maxWindowBits {
+		if dp.serverMaxWindowBits == 0 || dp.serverMaxWindowBits > bits {
+			return nil, errors.New("server_max_window_bits exceeds the offered value")
+		}
+	}
+
+	if pmd.ServerNoContextTakeover && !dp.serverNoContextTakeover {
+		return nil, errors.New("server_no_context_takeover was not accepted")
+	}
+
+	// The client is free to compress with a smaller window or no context
+	// takeover than the server allows.
+	dp.clientNoContextTakeover = dp.clientNoContextTakeover || pmd.ClientNoContextTakeover
+	dp.clientMaxWindowBits = min(windowBits(dp.clientMaxWindowBits), windowBits(pmd.ClientMaxWindowBits))
+
+	return newDeflateConn(dp, true, pmd.level()), nil
+}
+
+var (
+	flateWriterPools [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+	flateReaderPool  sync.Pool
+)
+
+// deflateConn holds the per connection permessage-deflate state.
+type deflateConn struct {
+	level int
+
+	// reset the compressor after each message.
+	writeNoContextTakeover bool
+
+	// the peer resets its compressor after each message.
+	readNoContextTakeover bool
+
+	fw   *flate.Writer
+	tw   truncWriter
+	dict []byte
+}
+
+func newDeflateConn(dp deflateParams, isClient bool, level int) *deflateConn {
+	dc := &deflateConn{
+		level:                  level,
+		writeNoContextTakeover: dp.serverNoContextTakeover,
+		readNoContextTakeover:  dp.clientNoContextTakeover,
+	}
+
+	writeBits := dp.serverMaxWindowBits
+	if isClient {
+		dc.writeNoContextTakeover = dp.clientNoContextTakeover
+		dc.readNoContextTakeover = dp.serverNoContextTakeover
+		writeBits = dp.clientMaxWindowBits
+	}
+
+	// compress/flate always uses a 32 KB window, a smaller negotiated window
+	// can only be honoured by not emitting any back-references.
+	if windowBits(writeBits) < maxWindowBits {
+		dc.level = flate.HuffmanOnly
+	}
+
+	return dc
+}
+
+// RSV returns [RSV1], the "Per-Message Compressed" bit, RFC 7692 (Section 6).
+func (dc *deflateConn) RSV() byte {
+	return RSV1
+}
+
+// NewWriter returns a writer which compresses a single message into w.
+func (dc *deflateConn) NewWriter(w io.WriteCloser, _ int) (io.WriteCloser, byte) {
+	dc.tw.w = w
+	dc.tw.flushSize = WriteBufSize
+	if dc.fw == nil {
+		if fw, ok := flateWriterPools[dc.level-flate.HuffmanOnly].Get().(*flate.Writer); ok {
+			fw.Reset(&dc.tw)
+			dc.fw = fw
+		} else {
+			dc.fw, _ = flate.NewWriter(&dc.tw, dc.level)
+		}
+	}
+
+	return &deflateWriter{dc: dc, w: w}, RSV1
+}
+
+// NewReader returns a reader which decompresses a single message from r,
+// messages without the RSV1 bit set are not compressed.
+func (dc *deflateConn) NewReader(r io.Reader, rsv byte) io.Reader {
+	if rsv&RSV1 == 0 {
+		return r
+	}
+
+	var dict []byte
+	if !dc.readNoContextTakeover {
+		dict = dc.dict
+	}
+
+	src := io.MultiReader(r, strings.NewReader(deflateTail))
+	fr, ok := flateReaderPool.Get().(io.ReadCloser)
+	if ok {
+		fr.(flate.Resetter).Reset(src, dict)
+	} else {
+		fr = flate.NewReaderDict(src, dict)
+	}
+
+	return &deflateReader{dc: dc, r: r, fr: fr}
+}
+
+// appendDict keeps the last maxWindowSize bytes of decompressed data.
+func (dc *deflateConn) appendDict(p []byte) {
+	if len(p) >= maxWindowSize {
+		dc.dict = append(dc.dict[:0], p[len(p)-maxWindowSize:]...)
+		return
+	}
+
+	if over := len(dc.dict) + len(p) - maxWindowSize; over > 0 {
+		dc.dict = dc.dict[:copy(dc.dict, dc.dict[over:])]
+	}
+
+	dc.dict = append(dc.dict, p...)
+}
+
+type deflateWriter struct {
+	dc     *deflateConn
+	w      io.WriteCloser
+	closed bool
+}
+
+func (dw *deflateWriter) Write(p []byte) (int, error) {
+	if dw.closed {
+		return 0, errInvalidWrite
+	}
+
+	return dw.dc.fw.Write(p)
+}
+
+func (dw *deflateWriter) Close() error {
+	if dw.closed {
+		return nil
+	}
+
+	dw.closed = true
+	err := dw.flush()
+	if cerr := dw.w.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// flush writes the rest of the compressed message.
+func (dw *deflateWriter) flush() error {
+	dc := dw.dc
+	err := dc.fw.Flush()
+
+	// RFC 7692 (Section 7.2.1)
+	//
+	// Remove 4 octets (that are 0x00 0x00 0xff 0xff) from the tail end.
+	if err == nil && !bytes.HasSuffix(dc.tw.buf, []byte(deflateTail[:4])) {
+		err = errors.New("bisoc: unexpected end of compressed message")
+	}
+
+	if err == nil && len(dc.tw.buf) > 4 {
+		_, err = dc.tw.w.Write(dc.tw.buf[:len(dc.tw.buf)-4])
+	}
+	dc.tw.buf = dc.tw.buf[:0]
+
+	if err != nil {
+		// the compressor state no longer matches the peer's.
+		dc.fw = nil
+		return err
+	}
+
+	if dc.writeNoContextTakeover {
+		flateWriterPools[dc.level-flate.HuffmanOnly].Put(dc.fw)
+		dc.fw = nil
+	}
+
+	return nil
+}
+
+// compressMessage compresses a whole message without context takeover, the
+// same way a deflateConn with writeNoContextTakeover does.
+func compressMessage(p string, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	pool := &flateWriterPools[level-flate.HuffmanOnly]
+	fw, ok := pool.Get().(*flate.Writer)
+	if ok {
+		fw.Reset(&buf)
+	} else {
+		fw, _ = flate.NewWriter(&buf, level)
+	}
+	defer pool.Put(fw)
+
+	if _, err := io.WriteString(fw, p); err != nil {
+		return nil, err
+	}
+
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+
+	b := buf.Bytes()
+	if !bytes.HasSuffix(b, []byte(deflateTail[:4])) {
+		return nil, errors.New("bisoc: unexpected end of compressed message")
+	}
+
+	return b[:len(b)-4], nil
+}
+
+// truncWriter buffers the compressed output and writes it to w in chunks of
+// flushSize, always holding back the last 4 bytes of the output.
+type truncWriter struct {
+	w         io.Writer
+	buf       []byte
+	flushSize int
+}
+
+func (tw *truncWriter) Write(p []byte) (int, error) {
+	tw.buf = append(tw.buf, p...)
+	if n := len(tw.buf) - 4; n >= tw.flushSize {
+		if _, err := tw.w.Write(tw.buf[:n]); err != nil {
+			return 0, err
+		}
+
+		tw.buf = tw.buf[:copy(tw.buf, tw.buf[n:])]
+	}
+
+	return len(p), nil
+}
+
+type deflateReader struct {
+	dc *deflateConn
+	r  io.Reader // compressed payload
+	fr io.ReadCloser
+}
+
+func (dr *deflateReader) Read(p []byte) (int, error) {
+	if dr.fr == nil {
+		return 0, io.EOF
+	}
+
+	n, err := dr.fr.Read(p)
+	if n > 0 && !dr.dc.readNoContextTakeover {
+		dr.dc.appendDict(p[:n])
+	}
+
+	switch err.(type) {
+	case nil:
+	case flate.CorruptInputError:
+		err = &CloseError{Code: StatusInvalidFramePayloadData, Reason: "invalid compressed data"}
+	default:
+		if err == io.EOF {
+			// the sender may end the deflate stream before the end of the
+			// message, discard the rest to stay in sync with the frames.
+			if _, derr := io.Copy(io.Discard, dr.r); derr != nil {
+				return n, derr
+			}
+
+			dr.fr.Close()
+			flateReaderPool.Put(dr.fr)
+			dr.fr = nil
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,356 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"testing"
+)
+
+// params parses the parameters of an extension, e.g. "a; b=1".
+func params(s string) []ExtensionParam {
+	var ps []ExtensionParam
+	for p := range strings.SplitSeq(s, ";") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+
+		name, value, _ := strings.Cut(p, "=")
+		ps = append(ps, ExtensionParam{Name: name, Value: value})
+	}
+
+	return ps
+}
+
+func TestDeflateAccept(t *testing.T) {
+	tests := []struct {
+		name   string
+		server PerMessageDeflate
+		offer  string
+		ok     bool
+		want   string // parameters of the response
+	}{
+		{"default", PerMessageDeflate{}, "client_max_window_bits", true, ""},
+		{"empty offer", PerMessageDeflate{}, "", true, ""},
+		{"server no context takeover", PerMessageDeflate{}, "server_no_context_takeover", true, "server_no_context_takeover"},
+		{"client no context takeover", PerMessageDeflate{ClientNoContextTakeover: true}, "", true, "client_no_context_takeover"},
+		{"server window", PerMessageDeflate{}, "server_max_window_bits=10", true, "server_max_window_bits=10"},
+		{"smaller server window", PerMessageDeflate{ServerMaxWindowBits: 9}, "server_max_window_bits=12", true, "server_max_window_bits=9"},
+		{"client window", PerMessageDeflate{ClientMaxWindowBits: 9}, "client_max_window_bits", true, "client_max_window_bits=9"},
+		{"client window offered", PerMessageDeflate{ClientMaxWindowBits: 12}, "client_max_window_bits=10", true, "client_max_window_bits=10"},
+		{"client window unsupported", PerMessageDeflate{ClientMaxWindowBits: 9}, "", true, ""},
+		{"duplicate", PerMessageDeflate{}, "server_no_context_takeover; server_no_context_takeover", false, ""},
+		{"duplicate window", PerMessageDeflate{}, "client_max_window_bits; client_max_window_bits=10", false, ""},
+		{"unknown", PerMessageDeflate{}, "mux", false, ""},
+		{"value", PerMessageDeflate{}, "server_no_context_takeover=1", false, ""},
+		{"window too small", PerMessageDeflate{}, "server_max_window_bits=7", false, ""},
+		{"window too large", PerMessageDeflate{}, "server_max_window_bits=16", false, ""},
+		{"window leading zero", PerMessageDeflate{}, "server_max_window_bits=010", false, ""},
+		{"window not a number", PerMessageDeflate{}, "client_max_window_bits=x", false, ""},
+		{"window without value", PerMessageDeflate{}, "server_max_window_bits", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, tr, ok := tt.server.Accept(params(tt.offer))
+			if ok != tt.ok {
+				t.Fatalf("accepted %v, want %v", ok, tt.ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			if tr == nil {
+				t.Fatal("no transform for an accepted offer")
+			}
+
+			if got, want := formatExtension(deflateExtension, resp), formatExtension(deflateExtension, params(tt.want)); got != want {
+				t.Fatalf("got response %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDeflateConfirm(t *testing.T) {
+	tests := []struct {
+		name     string
+		client   PerMessageDeflate
+		response string
+		ok       bool
+	}{
+		{"default", PerMessageDeflate{}, "", true},
+		{"client window", PerMessageDeflate{}, "client_max_window_bits=10", true},
+		{"client no context takeover", PerMessageDeflate{}, "client_no_context_takeover", true},
+		{"server window", PerMessageDeflate{ServerMaxWindowBits: 10}, "server_max_window_bits=9", true},
+		{"server window missing", PerMessageDeflate{ServerMaxWindowBits: 10}, "", false},
+		{"server window exceeded", PerMessageDeflate{ServerMaxWindowBits: 10}, "server_max_window_bits=12", false},
+		{"server no context takeover", PerMessageDeflate{ServerNoContextTakeover: true}, "server_no_context_takeover", true},
+		{"server no context takeover missing", PerMessageDeflate{ServerNoContextTakeover: true}, "", false},
+		{"client window without value", PerMessageDeflate{}, "client_max_window_bits", false},
+		{"duplicate", PerMessageDeflate{}, "client_no_context_takeover; client_no_context_takeover", false},
+		{"unknown", PerMessageDeflate{}, "mux", false},
+		{"bad window", PerMessageDeflate{}, "client_max_window_bits=20", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.client.Confirm(params(tt.response))
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+// deflatePair negotiates permessage-deflate between server and client and
+// returns the transforms of both sides.
+func deflatePair(t *testing.T, server, client *PerMessageDeflate) (*deflateConn, *deflateConn) {
+	t.Helper()
+
+	resp, st, ok := server.Accept(client.Offer())
+	if !ok {
+		t.Fatal("the offer was declined")
+	}
+
+	ct, err := client.Confirm(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return st.(*deflateConn), ct.(*deflateConn)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// compress returns the payload of msg compressed by dc.
+func compress(t *testing.T, dc *deflateConn, msg []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, rsv := dc.NewWriter(nopWriteCloser{&buf}, BinMsg)
+	if rsv != RSV1 {
+		t.Fatalf("got rsv %d, want RSV1", rsv)
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// decompress returns the message of the payload p decompressed by dc.
+func decompress(t *testing.T, dc *deflateConn, p []byte) []byte {
+	t.Helper()
+
+	msg, err := io.ReadAll(dc.NewReader(bytes.NewReader(p), RSV1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+// randomText returns n bytes of lowercase text which compresses poorly on
+// its own.
+func randomText(n int) []byte {
+	r := rand.New(rand.NewPCG(1, 2))
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = 'a' + byte(r.IntN(26))
+	}
+
+	return b
+}
+
+func TestDeflateContextTakeover(t *testing.T) {
+	msg := randomText(4096)
+
+	tests := []struct {
+		name           string
+		server, client PerMessageDeflate
+		// the side which reuses its window, or not
+		fromClient bool
+		takeover   bool
+	}{
+		{"server takeover", PerMessageDeflate{}, PerMessageDeflate{}, false, true},
+		{"client takeover", PerMessageDeflate{}, PerMessageDeflate{}, true, true},
+		{"server no context takeover", PerMessageDeflate{ServerNoContextTakeover: true}, PerMessageDeflate{}, false, false},
+		{"client no context takeover", PerMessageDeflate{}, PerMessageDeflate{ClientNoContextTakeover: true}, true, false},
+		{"requested by the client", PerMessageDeflate{}, PerMessageDeflate{ServerNoContextTakeover: true}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, ct := deflatePair(t, &tt.server, &tt.client)
+			w, r := st, ct
+			if tt.fromClient {
+				w, r = ct, st
+			}
+
+			var sizes []int
+			for range 3 {
+				p := compress(t, w, msg)
+				sizes = append(sizes, len(p))
+
+				if got := decompress(t, r, p); !bytes.Equal(got, msg) {
+					t.Fatal("the message was not decompressed by the peer")
+				}
+			}
+
+			// a repeated message is a back-reference to the previous one if
+			// the window is kept.
+			for _, n := range sizes[1:] {
+				if reused := n < sizes[0]/4; reused != tt.takeover {
+					t.Fatalf("got compressed sizes %v, want context takeover %v", sizes, tt.takeover)
+				}
+			}
+		})
+	}
+}
+
+func TestDeflateSmallWindow(t *testing.T) {
+	// compress/flate always uses a 32 KB window, so a smaller window is
+	// honoured with Huffman coding only.
+	st, ct := deflatePair(t, &PerMessageDeflate{}, &PerMessageDeflate{ServerMaxWindowBits: 10})
+	if st.level != flate.HuffmanOnly {
+		t.Fatalf("the server compresses with level %d, want HuffmanOnly", st.level)
+	}
+
+	if ct.level == flate.HuffmanOnly {
+		t.Fatal("the client compresses with HuffmanOnly, its window is not limited")
+	}
+
+	msg := bytes.Repeat([]byte("abcdefgh"), 4096)
+	for range 2 {
+		p := compress(t, st, msg)
+
+		// without back-references every byte takes at least one bit.
+		if len(p) < len(msg)/8 {
+			t.Fatalf("%d bytes compressed to %d bytes, want no back-references", len(msg), len(p))
+		}
+
+		if got := decompress(t, ct, p); !bytes.Equal(got, msg) {
+			t.Fatal("the message was not decompressed by the peer")
+		}
+	}
+}
+
+// compressedPipe returns a client and a server connection which negotiated
+// permessage-deflate, connected by a net.Pipe.
+func compressedPipe(t *testing.T) (*Conn, *Conn) {
+	t.Helper()
+
+	st, ct := deflatePair(t, &PerMessageDeflate{}, &PerMessageDeflate{})
+	a, b := net.Pipe()
+
+	client := newConn(a, true, nil, nil)
+	client.setExtensions([]ExtensionTransform{ct})
+	server := newConn(b, false, nil, nil)
+	server.setExtensions([]ExtensionTransform{st})
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	return client, server
+}
+
+func TestDeflateReadLimit(t *testing.T) {
+	client, server := compressedPipe(t)
+	server.SetReadLimit(4096)
+
+	// the close frame of the server is read by the client.
+	closed := make(chan error, 1)
+	go func() {
+		_, _, err := client.RecvMsg()
+		closed <- err
+	}()
+
+	// 1 MB of zeros compresses to about 1 KB, below the read limit.
+	sent := make(chan error, 1)
+	go func() {
+		sent <- client.SendMsg(BinMsg, string(make([]byte, 1<<20)))
+	}()
+
+	var ce *CloseError
+	if _, _, err := server.RecvMsg(); !errors.As(err, &ce) || ce.Code != StatusMessageTooBig {
+		t.Fatalf("got %v, want a close error with status %d", err, StatusMessageTooBig)
+	}
+
+	// the close frame of the client is not awaited.
+	server.Close()
+
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-closed; !errors.As(err, &ce) || ce.Code != StatusMessageTooBig {
+		t.Fatalf("the client got %v, want the close frame %d", err, StatusMessageTooBig)
+	}
+}
+
+func TestDeflateRSV1(t *testing.T) {
+	compressed, err := compressMessage("hello", flate.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		frames []*Frame
+	}{
+		{"continuation", []*Frame{
+			{Opcode: TextMsg, RSV: RSV1, Payload: compressed},
+			{Fin: true, Opcode: continuation, RSV: RSV1},
+		}},
+		{"ping", []*Frame{
+			{Fin: true, Opcode: PingMsg, RSV: RSV1},
+		}},
+		{"close", []*Frame{
+			{Fin: true, Opcode: CloseMsg, RSV: RSV1},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			defer b.Close()
+
+			st, _ := deflatePair(t, &PerMessageDeflate{}, &PerMessageDeflate{})
+			server := newConn(a, false, nil, nil)
+			server.setExtensions([]ExtensionTransform{st})
+			defer server.Close()
+
+			// the client writes the frames and reads the close frame.
+			go func() {
+				for _, f := range tt.frames {
+					if WriteFrame(b, true, f) != nil {
+						return
+					}
+				}
+				io.Copy(io.Discard, b)
+			}()
+
+			var ce *CloseError
+			if _, _, err := server.RecvMsg(); !errors.As(err, &ce) || ce.Code != StatusProtocolError {
+				t.Fatalf("got %v, want a close error with status %d", err, StatusProtocolError)
+			}
+		})
+	}
+}
@@ -4,7 +4,10 @@
 
 package bisoc
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 // Globally Unique Identifier (GUID) used for generating
 // 'Sec-WebSocket-Accept' by server during initial handshake.
@@ -35,12 +38,17 @@ const (
 	PongMsg = 0xA
 )
 
+// Reserved bits of the frame header (0th byte) which may be claimed by
+// an [Extension], described in RFC 6455 (Section 5.2).
 const (
-	// Frame header (0th byte) bit definations, described in RFC 6455 (Section 5.2)
-	fin  = 1 << 7
-	rsv1 = 1 << 6
-	rsv2 = 1 << 5
-	rsv3 = 1 << 4
+	RSV1 = 1 << 6
+	RSV2 = 1 << 5
+	RSV3 = 1 << 4
+)
+
+const (
+	// Frame header (0th byte) bit defination, described in RFC 6455 (Section 5.2)
+	fin = 1 << 7
 
 	// Frame header (1st byte) bit defination, described in RFC 6455 (Section 5.2)
 	masked = 1 << 7
@@ -58,6 +66,8 @@ const (
 const (
 	badHandShake = "bisoc: illegal handshake by client: "
 
+	badServerHandShake = "bisoc: illegal handshake by server: "
+
 	// Minimum read and write buffer sizes
 	minBufSize = 512
 
@@ -72,6 +82,18 @@ const (
 
 	// Max consecutive empty continuation frames before connection is dropped
 	maxEmptyFrames = 10
+
+	// Default duration to wait for the peer's close frame in CloseWithCode
+	CloseTimeout = 5 * time.Second
+
+	// Default number of messages queued for a connection of a Hub
+	HubQueueSize = 64
+
+	// Default number of messages queued by Conn.Enqueue
+	SendQueueSize = 64
+
+	// Default number of bytes read by Server.Listener before the handshake completes (16 KB)
+	MaxHeaderBytes = 16 << 10
 )
 
 // Connection Close Code Numbers as described in RFC 6455 (Section 11.7).
@@ -94,5 +116,20 @@ const (
 )
 
 var (
-	errInvalidWrite = errors.New("write to a closed writer")
+	// ErrCloseSent is returned when writing to a connection after a close
+	// frame was sent.
+	ErrCloseSent = errors.New("bisoc: close frame already sent")
+
+	// ErrClosed is returned when using a connection after it is closed.
+	ErrClosed = errors.New("bisoc: use of closed connection")
+
+	// ErrServerClosed is returned by [Server.Accept] after the server was
+	// shut down, use errors.Is as it is wrapped in a [*HandshakeError] when
+	// the handshake is rejected.
+	ErrServerClosed = errors.New("bisoc: server closed")
+
+	errInvalidWrite       = errors.New("write to a closed writer")
+	errInvalidMsgKind     = errors.New("bisoc: message kind must be TextMsg or BinMsg")
+	errInvalidCloseCode   = errors.New("bisoc: invalid close code")
+	errCloseReasonTooLong = errors.New("bisoc: close reason too long")
 )
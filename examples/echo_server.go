@@ -10,7 +10,7 @@ import (
 var server = &bisoc.Server{}
 
 func echo(w http.ResponseWriter, r *http.Request) {
-	c, err := server.Accept(w, r)
+	c, err := server.Accept(w, r, nil)
 	if err != nil {
 		log.Printf("Error: %v\n", err)
 		return
@@ -0,0 +1,250 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Extension is a WebSocket extension negotiated in the opening handshake
+// through the 'Sec-WebSocket-Extensions' header, as described in
+// RFC 6455 (Section 9).
+//
+// An extension is configured on a [Server] or a [Dialer] and is shared by
+// all of their connections, the per connection state is held by the
+// [ExtensionTransform] returned from a successful negotiation.
+type Extension interface {
+	// Name returns the extension token, e.g. "permessage-deflate".
+	Name() string
+
+	// Offer returns the parameters offered by a [Dialer] in the opening
+	// handshake.
+	Offer() []ExtensionParam
+
+	// Accept is called by a [Server] for an offer of the client, it returns
+	// the parameters of the response and the transform for the connection,
+	// or ok == false to decline the offer. If the client makes several offers
+	// for the extension, Accept is called for each of them in order until one
+	// is accepted.
+	Accept(offer []ExtensionParam) (response []ExtensionParam, t ExtensionTransform, ok bool)
+
+	// Confirm is called by a [Dialer] with the parameters of the server's
+	// response, a non-nil error fails the opening handshake.
+	Confirm(response []ExtensionParam) (ExtensionTransform, error)
+}
+
+// ExtensionTransform is the per connection state of a negotiated [Extension],
+// it transforms the payload of data messages.
+//
+// NewReader is only called by the reader of the connection and NewWriter by
+// one writer at a time, so a transform may keep state between messages.
+type ExtensionTransform interface {
+	// RSV returns the rsv bits claimed by the extension, a combination of
+	// [RSV1], [RSV2] and [RSV3]. Claimed bits may only be set on the first
+	// frame of a data message, the bits claimed by the negotiated extensions
+	// of a connection must not overlap.
+	RSV() byte
+
+	// NewReader returns a reader which transforms the payload of an incoming
+	// data message read from r, rsv holds the claimed bits of the message's
+	// first frame. It is called for every data message, return r to leave
+	// the payload unchanged.
+	NewReader(r io.Reader, rsv byte) io.Reader
+
+	// NewWriter returns a writer which transforms the payload of an outgoing
+	// data message into w along with the rsv bits to set on the message's
+	// first frame. Closing the returned writer must close w, even if the
+	// message could not be written.
+	NewWriter(w io.WriteCloser, msgType int) (io.WriteCloser, byte)
+}
+
+// ExtensionParam is a parameter of an extension, Value is empty for
+// parameters without a value.
+type ExtensionParam struct {
+	Name  string
+	Value string
+}
+
+// extension is a single extension of a 'Sec-WebSocket-Extensions' header.
+type extension struct {
+	name   string
+	params []ExtensionParam
+}
+
+// parseExtensions parses the 'Sec-WebSocket-Extensions' header fields in order,
+// as described in RFC 6455 (Section 9.1). Malformed extensions are skipped.
+func parseExtensions(h http.Header) []extension {
+	var exts []extension
+	for _, header := range h["Sec-Websocket-Extensions"] {
+	next:
+		for item := range strings.SplitSeq(header, ",") {
+			parts := strings.Split(item, ";")
+			ext := extension{name: strings.TrimSpace(parts[0])}
+			if !isToken(ext.name) {
+				continue
+			}
+
+			for _, part := range parts[1:] {
+				name, value, _ := strings.Cut(part, "=")
+				p := ExtensionParam{
+					Name:  strings.TrimSpace(name),
+					Value: strings.TrimSpace(value),
+				}
+
+				// RFC 6455 (Section 9.1)
+				//
+				// When using the quoted-string syntax variant, the value
+				// after quoted-string unescaping MUST conform to the
+				// 'token' ABNF.
+				if strings.HasPrefix(p.Value, "\"") {
+					uq, err := strconv.Unquote(p.Value)
+					if err != nil {
+						continue next
+					}
+					p.Value = uq
+				}
+
+				if !isToken(p.Name) || (p.Value != "" && !isToken(p.Value)) {
+					continue next
+				}
+
+				ext.params = append(ext.params, p)
+			}
+
+			exts = append(exts, ext)
+		}
+	}
+
+	return exts
+}
+
+// formatExtension formats an extension for the 'Sec-WebSocket-Extensions' header.
+func formatExtension(name string, params []ExtensionParam) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, p := range params {
+		b.WriteString("; ")
+		b.WriteString(p.Name)
+		if p.Value != "" {
+			b.WriteString("=")
+			b.WriteString(p.Value)
+		}
+	}
+
+	return b.String()
+}
+
+// isToken reports whether s is a valid HTTP token, RFC 7230 (Section 3.2.6).
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range []byte(s) {
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("()<>@,;:\\\"/[]?={}", c) >= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// offerExtensions returns the value of the client's 'Sec-WebSocket-Extensions'
+// header for the supported extensions.
+func offerExtensions(supported []Extension) string {
+	offers := make([]string, 0, len(supported))
+	for _, ext := range supported {
+		offers = append(offers, formatExtension(ext.Name(), ext.Offer()))
+	}
+
+	return joinHeader(offers)
+}
+
+// acceptExtensions accepts the client's offers in order with the supported
+// extensions and returns the value of the server's 'Sec-WebSocket-Extensions'
+// header. Offers whose rsv bits overlap with an accepted extension are declined.
+func acceptExtensions(supported []Extension, offers []extension) (string, []ExtensionTransform) {
+	var (
+		names      []string
+		accepted   []string
+		transforms []ExtensionTransform
+		rsv        byte
+	)
+
+	for _, offer := range offers {
+		ext := findExtension(supported, offer.name)
+		if ext == nil || containsExtension(names, offer.name) {
+			continue
+		}
+
+		params, t, ok := ext.Accept(offer.params)
+		if !ok || t.RSV()&rsv != 0 {
+			continue
+		}
+
+		rsv |= t.RSV()
+		names = append(names, offer.name)
+		accepted = append(accepted, formatExtension(offer.name, params))
+		transforms = append(transforms, t)
+	}
+
+	return joinHeader(accepted), transforms
+}
+
+// confirmExtensions validates the extensions accepted by the server, the
+// server may only accept extensions offered by the client, RFC 6455 (Section 9.1).
+func confirmExtensions(offered []Extension, accepted []extension) ([]ExtensionTransform, error) {
+	var (
+		names      []string
+		transforms []ExtensionTransform
+		rsv        byte
+	)
+
+	for _, a := range accepted {
+		ext := findExtension(offered, a.name)
+		if ext == nil || containsExtension(names, a.name) {
+			return nil, errors.New("unsolicited extension " + a.name)
+		}
+
+		t, err := ext.Confirm(a.params)
+		if err != nil {
+			return nil, errors.New(a.name + ": " + err.Error())
+		}
+
+		if t.RSV()&rsv != 0 {
+			return nil, errors.New(a.name + ": rsv bits already claimed by another extension")
+		}
+
+		rsv |= t.RSV()
+		names = append(names, a.name)
+		transforms = append(transforms, t)
+	}
+
+	return transforms, nil
+}
+
+func findExtension(exts []Extension, name string) Extension {
+	for _, ext := range exts {
+		if strings.EqualFold(ext.Name(), name) {
+			return ext
+		}
+	}
+
+	return nil
+}
+
+func containsExtension(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+
+	return false
+}
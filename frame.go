@@ -0,0 +1,313 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"errors"
+	"io"
+)
+
+// Frame is a single WebSocket frame, as described in RFC 6455 (Section 5.2).
+//
+// The payload of a frame is never masked, masking is applied while writing
+// and removed while reading a frame. The payload of a data frame is not
+// transformed by the negotiated extensions.
+type Frame struct {
+	Fin     bool
+	RSV     byte // combination of RSV1, RSV2 and RSV3
+	Opcode  int  // TextMsg, BinMsg, CloseMsg, PingMsg, PongMsg or zero for a continuation frame
+	Payload []byte
+}
+
+// validate checks the rules for a frame which do not depend on the
+// connection it is sent on.
+func (f *Frame) validate() error {
+	if f.RSV&^(RSV1|RSV2|RSV3) != 0 {
+		return errors.New("bisoc: invalid rsv bits")
+	}
+
+	if isControlFrame(f.Opcode) {
+		// RFC 6455 (Section 5.5)
+		//
+		// All control frames MUST have a payload length of 125 bytes or less
+		// and MUST NOT be fragmented.
+		if !f.Fin {
+			return errors.New("bisoc: control frame must not be fragmented")
+		}
+
+		if len(f.Payload) > maxControlFramePayloadSize {
+			return errors.New("bisoc: control frame payload data too big")
+		}
+
+		return nil
+	}
+
+	if !isDataFrame(f.Opcode) && f.Opcode != continuation {
+		return errors.New("bisoc: invalid opcode")
+	}
+
+	return nil
+}
+
+// ReadFrame reads a single frame from r, isClient reports whether the frame
+// is read by a client. The frame is checked for the masking and control frame
+// rules, but as the negotiated extensions are unknown, the rsv bits are not.
+// A frame whose payload is larger than [ReadLimit] is rejected.
+//
+// Protocol violations are reported as a [*CloseError].
+func ReadFrame(r io.Reader, isClient bool) (*Frame, error) {
+	return readFrame(r, isClient, ReadLimit)
+}
+
+// WriteFrame writes a single frame to w, isClient reports whether the frame
+// is written by a client, which masks it with a new masking key.
+func WriteFrame(w io.Writer, isClient bool, f *Frame) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+
+	var header [maxFrameHeaderSize]byte
+	n := putHeader(header[:], f.Opcode, f.RSV, f.Fin, len(f.Payload), isClient)
+
+	b := make([]byte, 0, n+4+len(f.Payload))
+	b = append(b, header[:n]...)
+	if isClient {
+		maskKey := newMaskKey()
+		b = append(b, maskKey[:]...)
+		b = append(b, f.Payload...)
+		maskBytes(maskKey[:], 0, b[n+4:])
+	} else {
+		b = append(b, f.Payload...)
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+// readFrame reads a single frame from r, see ReadFrame.
+func readFrame(r io.Reader, isClient bool, limit int) (*Frame, error) {
+	header, err := readBytes(r, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Frame{
+		Fin:    header[0]&fin != 0,
+		RSV:    header[0] & (RSV1 | RSV2 | RSV3),
+		Opcode: int(header[0] & 0x0F),
+	}
+
+	switch {
+	case isControlFrame(f.Opcode):
+		if !f.Fin {
+			return nil, &CloseError{Code: StatusProtocolError, Reason: "fin bit not set in control frame"}
+		}
+	case isDataFrame(f.Opcode), f.Opcode == continuation:
+	default:
+		return nil, &CloseError{Code: StatusProtocolError, Reason: "reserved opcode"}
+	}
+
+	l, mask, err := readExtensions(r, header, isClient, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if isControlFrame(f.Opcode) && l > maxControlFramePayloadSize {
+		return nil, &CloseError{Code: StatusInvalidFramePayloadData, Reason: "control frame payload data too big"}
+	}
+
+	f.Payload, err = readPayload(r, int(l))
+	if err != nil {
+		return nil, err
+	}
+
+	if mask != nil {
+		maskBytes(mask, 0, f.Payload)
+	}
+
+	return f, nil
+}
+
+// maxPayloadAlloc is the largest payload which is allocated before it is
+// read, see readPayload.
+const maxPayloadAlloc = 64 << 10
+
+// readPayload reads a payload of n bytes from r. A larger payload than
+// maxPayloadAlloc is read into a growing buffer, so that the length claimed
+// by a frame header is only allocated once the payload arrives.
+func readPayload(r io.Reader, n int) ([]byte, error) {
+	if n <= maxPayloadAlloc {
+		return readBytes(r, n)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err == nil && len(b) < n {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return b, err
+}
+
+// ReadFrame reads the next frame from the connection, it must not be used
+// while a message is read with NextReader. The rest of a message returned
+// by an earlier NextReader is discarded.
+//
+// The frames are checked for the same rules as the frames of a message read
+// with RecvMsg, but the payload is returned as received: it is neither
+// transformed by the negotiated extensions nor validated as UTF-8. Ping and
+// pong frames are passed to their handlers before they are returned, a close
+// frame is passed to the close handler and returned as a [*CloseError]. The
+// read limit applies to the total size of the frames of a message.
+func (ws *Conn) ReadFrame() (*Frame, error) {
+	ws.readMu.Lock()
+	defer ws.readMu.Unlock()
+
+	if ws.readErr != nil {
+		return nil, ws.readErr
+	}
+
+	if ws.reader != nil {
+		if _, err := io.Copy(io.Discard, ws.reader); err != nil {
+			return nil, ws.readFail(err)
+		}
+
+		ws.reader = nil
+	}
+
+	f, err := ws.nextFrame()
+	if err != nil {
+		return nil, ws.readFail(err)
+	}
+
+	return f, nil
+}
+
+// nextFrame reads and checks the next frame for ReadFrame.
+func (ws *Conn) nextFrame() (*Frame, error) {
+	// the read limit applies to the whole message, control frames between
+	// its fragments are not counted.
+	limit := ws.readLimit
+	if ws.frameContinues {
+		limit = max(limit-ws.frameMsgSize, maxControlFramePayloadSize)
+	}
+
+	f, err := readFrame(ws.br, ws.client, limit)
+	if err != nil {
+		return nil, err
+	}
+	ws.touch()
+
+	first := isDataFrame(f.Opcode)
+	if err := ws.validateRSV(f.RSV, first); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case isControlFrame(f.Opcode):
+		if err := ws.handleControlFrame(f.Opcode, f.Payload); err != nil {
+			return nil, err
+		}
+
+		return f, nil
+	case first && ws.frameContinues:
+		return nil, &CloseError{Code: StatusProtocolError, Reason: "continuation frame not sent"}
+	case !first && !ws.frameContinues:
+		return nil, &CloseError{Code: StatusProtocolError, Reason: "unexpected continuation frame"}
+	}
+
+	if first {
+		ws.frameMsgSize = 0
+	}
+
+	ws.frameMsgSize += len(f.Payload)
+	if ws.frameMsgSize > ws.readLimit {
+		return nil, &CloseError{Code: StatusMessageTooBig}
+	}
+
+	ws.frameContinues = !f.Fin
+	if f.Fin {
+		ws.messageRead()
+	}
+
+	return f, nil
+}
+
+// WriteFrame writes a single frame to the connection, the frame is masked by
+// a client.
+//
+// The frames of a fragmented message must be written by one goroutine, while
+// they are written no other message is sent on the connection: NextWriter
+// blocks until the final frame of the message was written, and WriteFrame
+// returns an error for a data frame starting another message. Control
+// frames can be written between the frames of a message.
+func (ws *Conn) WriteFrame(f *Frame) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+
+	if isControlFrame(f.Opcode) {
+		if f.RSV != 0 {
+			return errors.New("bisoc: rsv bits are set but not negotiated")
+		}
+
+		return ws.writeControl(f.Opcode, f.Payload)
+	}
+
+	if isDataFrame(f.Opcode) {
+		if f.RSV&^ws.rsv != 0 {
+			return errors.New("bisoc: rsv bits are set but not negotiated")
+		}
+
+		if err := ws.writeState(); err != nil {
+			return err
+		}
+
+		// the writer of the open message would wait for itself.
+		if ws.frameWriting.Load() {
+			return errors.New("bisoc: previous message not finished")
+		}
+
+		ws.msgLock <- struct{}{}
+		ws.frameWriting.Store(true)
+	} else {
+		if f.RSV != 0 {
+			return errors.New("bisoc: rsv bits are set but not negotiated")
+		}
+
+		if !ws.frameWriting.Load() {
+			return errors.New("bisoc: continuation frame without a message")
+		}
+	}
+
+	err := ws.writeFrame(f)
+	if f.Fin || err != nil {
+		// the message is complete or can not be continued, release writeBuf
+		// for the next message.
+		ws.frameWriting.Store(false)
+		<-ws.msgLock
+	}
+
+	return err
+}
+
+// writeFrame writes a data frame, msgLock must be held.
+func (ws *Conn) writeFrame(f *Frame) error {
+	b := ws.writeBuf
+	if need := maxFrameHeaderSize + len(f.Payload); need > len(b) {
+		b = make([]byte, need)
+	}
+
+	payload := b[maxFrameHeaderSize : maxFrameHeaderSize+len(f.Payload)]
+	copy(payload, f.Payload)
+
+	var header [maxFrameHeaderSize]byte
+	n := ws.encodeHeader(header[:], f.Opcode, f.RSV, f.Fin, payload)
+
+	// place the header right before the payload
+	start := maxFrameHeaderSize - n
+	copy(b[start:], header[:n])
+
+	return ws.writeRaw(b[start:maxFrameHeaderSize+len(payload)], false)
+}
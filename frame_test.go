@@ -0,0 +1,128 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestReadFrameClaimedLength(t *testing.T) {
+	// the header claims a payload of 60 MB, but only a few bytes follow.
+	b := []byte{fin | BinMsg, 127, 0, 0, 0, 0, 0x03, 0xC0, 0, 0}
+	b = append(b, "short"...)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	_, err := ReadFrame(bytes.NewReader(b), true)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Fatalf("allocated %d bytes for a short payload", n)
+	}
+}
+
+func TestReadFrameMessageLimit(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	c := newConn(a, true, nil, nil)
+	defer c.Close()
+	c.SetReadLimit(100)
+
+	// discard the pong and the close frame.
+	go io.Copy(io.Discard, b)
+
+	go func() {
+		frames := []*Frame{
+			{Opcode: BinMsg, Payload: make([]byte, 60)},
+			{Fin: true, Opcode: PingMsg},
+			{Opcode: continuation, Payload: make([]byte, 60)},
+		}
+		for _, f := range frames {
+			if err := WriteFrame(b, false, f); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, opcode := range []int{BinMsg, PingMsg} {
+		f, err := c.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f.Opcode != opcode {
+			t.Fatalf("got opcode %d, want %d", f.Opcode, opcode)
+		}
+	}
+
+	var ce *CloseError
+	if _, err := c.ReadFrame(); !errors.As(err, &ce) || ce.Code != StatusMessageTooBig {
+		t.Fatalf("got %v, want a close error with status %d", err, StatusMessageTooBig)
+	}
+}
+
+func TestWriteFrameUnfinishedMessage(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	c := newConn(a, true, nil, nil)
+	defer c.Close()
+
+	frames := make(chan *Frame, 4)
+	go func() {
+		for {
+			f, err := ReadFrame(b, false)
+			if err != nil {
+				return
+			}
+			frames <- f
+		}
+	}()
+
+	if err := c.WriteFrame(&Frame{Opcode: TextMsg, Payload: []byte("hel")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new message can not be started before the open one is finished.
+	written := make(chan error, 1)
+	go func() {
+		written <- c.WriteFrame(&Frame{Fin: true, Opcode: TextMsg, Payload: []byte("other")})
+	}()
+
+	select {
+	case err := <-written:
+		if err == nil {
+			t.Fatal("a message was started inside an unfinished message")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WriteFrame blocked on the unfinished message")
+	}
+
+	for _, f := range []*Frame{
+		{Fin: true, Opcode: continuation, Payload: []byte("lo")},
+		{Fin: true, Opcode: TextMsg, Payload: []byte("next")},
+	} {
+		if err := c.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range []string{"hel", "lo", "next"} {
+		if f := <-frames; string(f.Payload) != want {
+			t.Fatalf("got frame %q, want %q", f.Payload, want)
+		}
+	}
+}
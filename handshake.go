@@ -0,0 +1,163 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+// HandshakeInfo describes the opening handshake of a connection, e.g. for
+// logging or authorization decisions once the connection is established.
+type HandshakeInfo struct {
+	// URL is the url of the request, for a server it holds the path and
+	// query of the request and for a client the dialed url.
+	URL *url.URL
+
+	// Host is the host of the request.
+	Host string
+
+	// Header holds the headers of the request, without the :protocol
+	// pseudo-header field of an HTTP/2 request.
+	Header http.Header
+
+	// ResponseHeader holds the headers of the server's response for a
+	// client, it is nil for a server.
+	ResponseHeader http.Header
+
+	// Subprotocol is the negotiated subprotocol.
+	Subprotocol string
+
+	// Extensions are the negotiated extensions, as sent by the server in the
+	// Sec-WebSocket-Extensions header.
+	Extensions string
+
+	// RemoteAddr is the network address of the peer.
+	RemoteAddr string
+
+	// TLS is the state of the TLS connection, it is nil if the connection
+	// does not use TLS.
+	TLS *tls.ConnectionState
+}
+
+// Cookies parses and returns the cookies sent with the request.
+func (hi HandshakeInfo) Cookies() []*http.Cookie {
+	return (&http.Request{Header: hi.Header}).Cookies()
+}
+
+// clone returns a deep copy of hi.
+func (hi HandshakeInfo) clone() HandshakeInfo {
+	if hi.URL != nil {
+		u := *hi.URL
+		hi.URL = &u
+	}
+
+	hi.Header = hi.Header.Clone()
+	hi.ResponseHeader = hi.ResponseHeader.Clone()
+
+	if hi.TLS != nil {
+		state := *hi.TLS
+		state.PeerCertificates = slices.Clone(state.PeerCertificates)
+		state.VerifiedChains = slices.Clone(state.VerifiedChains)
+		for i, chain := range state.VerifiedChains {
+			state.VerifiedChains[i] = slices.Clone(chain)
+		}
+		hi.TLS = &state
+	}
+
+	return hi
+}
+
+// handshakeInfo returns the handshake of a connection accepted from r.
+func handshakeInfo(r *http.Request, subprotocol, extensions string) HandshakeInfo {
+	hi := HandshakeInfo{
+		URL:         r.URL,
+		Host:        r.Host,
+		Header:      r.Header,
+		Subprotocol: subprotocol,
+		Extensions:  extensions,
+		RemoteAddr:  r.RemoteAddr,
+		TLS:         r.TLS,
+	}.clone()
+
+	// the pseudo-header field of an extended CONNECT request is not a header.
+	delete(hi.Header, ":protocol")
+	return hi
+}
+
+// Handshake returns the opening handshake of the connection, the returned
+// value is a copy which can be modified.
+func (ws *Conn) Handshake() HandshakeInfo {
+	return ws.handshake.clone()
+}
+
+// HandshakeErrorKind is the reason an opening handshake was rejected by a
+// [Server], its String method returns a machine-readable form.
+type HandshakeErrorKind int
+
+const (
+	HandshakeMethodNotAllowed  HandshakeErrorKind = iota + 1 // request method is not GET, or CONNECT over HTTP/2
+	HandshakeBadUpgrade                                      // Upgrade, Connection or :protocol header is missing
+	HandshakeBadVersion                                      // Sec-WebSocket-Version is not 13
+	HandshakeOriginNotAllowed                                // rejected by CheckOrigin
+	HandshakeBadKey                                          // Sec-WebSocket-Key is invalid
+	HandshakeBadSubprotocol                                  // rejected by SelectSubprotocol
+	HandshakeBadResponseHeader                               // response header not allowed, see Server.Accept
+	HandshakeHijackFailed                                    // the connection could not be taken over
+	HandshakePipelined                                       // the client sent data before the handshake completed
+	HandshakeServerClosed                                    // the server was shut down
+	HandshakeBadRequest                                      // the request read by AcceptConn is malformed or too large
+	HandshakeTimeout                                         // HandShakeTimeout elapsed, the connection was closed
+)
+
+var handshakeErrorKinds = [...]string{
+	HandshakeMethodNotAllowed:  "method_not_allowed",
+	HandshakeBadUpgrade:        "bad_upgrade",
+	HandshakeBadVersion:        "bad_version",
+	HandshakeOriginNotAllowed:  "origin_not_allowed",
+	HandshakeBadKey:            "bad_key",
+	HandshakeBadSubprotocol:    "bad_subprotocol",
+	HandshakeBadResponseHeader: "bad_response_header",
+	HandshakeHijackFailed:      "hijack_failed",
+	HandshakePipelined:         "pipelined",
+	HandshakeServerClosed:      "server_closed",
+	HandshakeBadRequest:        "bad_request",
+	HandshakeTimeout:           "timeout",
+}
+
+func (k HandshakeErrorKind) String() string {
+	if k > 0 && int(k) < len(handshakeErrorKinds) {
+		return handshakeErrorKinds[k]
+	}
+
+	return "unknown"
+}
+
+// HandshakeError is returned by [Server.Accept] and [Server.AcceptConn] if the
+// opening handshake was rejected, the response was written with Status. No
+// response is written for a [HandshakeTimeout] as the connection is already
+// closed, OnHandshakeError is then not called.
+type HandshakeError struct {
+	Status  int                // status of the response
+	Kind    HandshakeErrorKind // reason of the rejection
+	Request *http.Request      // request of the rejected handshake, nil if it could not be read
+	Err     error              // cause of the rejection, if any, e.g. ErrServerClosed
+
+	msg string
+}
+
+func (e *HandshakeError) Error() string {
+	if e.msg == "" && e.Err != nil {
+		return e.Err.Error()
+	}
+
+	return e.msg
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,170 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Hub broadcasts prepared messages to a set of registered connections.
+//
+// Every connection has its own queue of outgoing messages, which is written
+// by a goroutine of the hub, so a slow connection does not hold back the
+// others. A connection whose queue is full when a message is broadcast, or
+// whose write fails, is evicted: it is unregistered and closed with
+// [StatusPolicyViolation].
+//
+// The zero value is an empty hub ready to use, its fields must not be
+// changed once a connection is registered.
+type Hub struct {
+	// QueueSize is the number of messages queued for a connection.
+	// If zero, HubQueueSize is used.
+	QueueSize int
+
+	// WriteTimeout bounds the time to write a message to a connection.
+	// If zero, writes are not bounded. The write deadline of the connection
+	// is set before and cleared after every write of the hub, which replaces
+	// a deadline set meanwhile by another writer, e.g. by SendMsgContext or
+	// by the keepalive closing the connection, so the connections of a hub
+	// with a WriteTimeout should only be written through the hub.
+	WriteTimeout time.Duration
+
+	mu    sync.Mutex
+	conns map[*Conn]*hubConn
+}
+
+// hubConn is the queue of a registered connection.
+type hubConn struct {
+	queue chan *PreparedMessage
+	stop  chan struct{} // closed once the connection is unregistered
+}
+
+// Register adds a connection to the hub, it is unregistered once the
+// connection is closed or reading from it fails, e.g. with a [*CloseError].
+func (h *Hub) Register(c *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.conns[c]; ok {
+		return
+	}
+
+	if h.conns == nil {
+		h.conns = make(map[*Conn]*hubConn)
+	}
+
+	size := h.QueueSize
+	if size <= 0 {
+		size = HubQueueSize
+	}
+
+	hc := &hubConn{
+		queue: make(chan *PreparedMessage, size),
+		stop:  make(chan struct{}),
+	}
+	h.conns[c] = hc
+
+	go h.writeLoop(c, hc)
+}
+
+// Unregister removes a connection from the hub without closing it, the
+// messages still queued for it are dropped.
+func (h *Hub) Unregister(c *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if hc, ok := h.conns[c]; ok {
+		h.remove(c, hc)
+	}
+}
+
+// Len returns the number of registered connections.
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.conns)
+}
+
+// Broadcast queues a message for all registered connections, it does not
+// wait for the message to be written.
+func (h *Hub) Broadcast(pm *PreparedMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c, hc := range h.conns {
+		select {
+		case hc.queue <- pm:
+		default:
+			h.evict(c, hc)
+		}
+	}
+}
+
+// remove unregisters c, h.mu must be held.
+func (h *Hub) remove(c *Conn, hc *hubConn) {
+	delete(h.conns, c)
+	close(hc.stop)
+}
+
+// evict unregisters c and closes it, h.mu must be held.
+func (h *Hub) evict(c *Conn, hc *hubConn) {
+	h.remove(c, hc)
+
+	// closing may wait for a pending write, which must not block the hub.
+	go c.abort(&CloseError{Code: StatusPolicyViolation, Reason: "slow consumer"}, StatusPolicyViolation)
+}
+
+// writeLoop writes the queued messages of c until it is unregistered.
+func (h *Hub) writeLoop(c *Conn, hc *hubConn) {
+	for {
+		var pm *PreparedMessage
+		select {
+		case <-hc.stop:
+			return
+		case <-c.done:
+			h.drop(c, hc, false)
+			return
+		case <-c.readDone:
+			h.drop(c, hc, false)
+			return
+		case pm = <-hc.queue:
+		}
+
+		if h.WriteTimeout > 0 {
+			c.SetWriteDeadline(time.Now().Add(h.WriteTimeout))
+		}
+
+		err := c.WritePreparedMessage(pm)
+		if err != nil {
+			// a connection which is already closing is only unregistered.
+			h.drop(c, hc, !errors.Is(err, ErrCloseSent) && !errors.Is(err, ErrClosed))
+			return
+		}
+
+		if h.WriteTimeout > 0 {
+			c.SetWriteDeadline(time.Time{})
+		}
+	}
+}
+
+// drop unregisters c if it is still registered with hc, and evicts it if
+// evict is true.
+func (h *Hub) drop(c *Conn, hc *hubConn, evict bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conns[c] != hc {
+		return
+	}
+
+	if evict {
+		h.evict(c, hc)
+	} else {
+		h.remove(c, hc)
+	}
+}
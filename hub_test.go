@@ -0,0 +1,132 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitLen waits until the hub has n connections.
+func waitLen(t *testing.T, h *Hub, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for h.Len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("the hub has %d connections, want %d", h.Len(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHubBroadcast(t *testing.T) {
+	var hub Hub
+	u := newTestServer(t, &Server{}, func(c *Conn) {
+		hub.Register(c)
+
+		// control frames are handled while reading.
+		for {
+			if _, _, err := c.NextReader(); err != nil {
+				return
+			}
+		}
+	})
+
+	conns := make([]*Conn, 16)
+	for i := range conns {
+		c, _, err := DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		conns[i] = c
+	}
+	waitLen(t, &hub, len(conns))
+
+	for i := range 3 {
+		pm, err := NewPreparedMessage(TextMsg, "message "+strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hub.Broadcast(pm)
+	}
+
+	for _, c := range conns {
+		for i := range 3 {
+			if _, p, err := c.RecvMsg(); err != nil || string(p) != "message "+strconv.Itoa(i) {
+				t.Fatalf("got %q, %v, want message %d", p, err, i)
+			}
+		}
+	}
+
+	// a closed connection is unregistered.
+	conns[0].CloseWithCode(StatusNormalClosure, "")
+	waitLen(t, &hub, len(conns)-1)
+}
+
+func TestHubEvictsSlowConsumer(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	c := newConn(a, false, nil, nil)
+	defer c.Close()
+
+	hub := &Hub{QueueSize: 1}
+	hub.Register(c)
+
+	pm, _ := NewPreparedMessage(TextMsg, "hello")
+
+	// the peer does not read, so the first message blocks the writer and the
+	// second fills the queue.
+	for hub.Len() == 1 {
+		hub.Broadcast(pm)
+	}
+
+	for {
+		f, err := ReadFrame(b, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f.Opcode == CloseMsg {
+			if len(f.Payload) < 2 || binary.BigEndian.Uint16(f.Payload) != StatusPolicyViolation {
+				t.Fatalf("got close frame %q, want status %d", f.Payload, StatusPolicyViolation)
+			}
+			break
+		}
+	}
+
+	if err := c.SendMsg(TextMsg, "late"); err == nil {
+		t.Fatal("an evicted connection is still open")
+	}
+}
+
+func TestHubWriteTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	c := newConn(a, false, nil, nil)
+	c.SetCloseTimeout(10 * time.Millisecond)
+	defer c.Close()
+
+	hub := &Hub{WriteTimeout: 20 * time.Millisecond}
+	hub.Register(c)
+
+	// the peer does not read, so the write times out.
+	pm, _ := NewPreparedMessage(TextMsg, "hello")
+	hub.Broadcast(pm)
+	waitLen(t, hub, 0)
+
+	var ce *CloseError
+	if _, _, err := c.RecvMsg(); !errors.As(err, &ce) || ce.Code != StatusPolicyViolation {
+		t.Fatalf("got %v, want the close error %d of the eviction", err, StatusPolicyViolation)
+	}
+}
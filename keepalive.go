@@ -0,0 +1,105 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// startKeepalive starts pinging the peer when nothing was read from the
+// connection for interval, the connection is closed if the pong does not
+// arrive within timeout.
+func (ws *Conn) startKeepalive(interval, timeout time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	if timeout <= 0 {
+		timeout = interval
+	}
+
+	ws.keepalive = true
+	ws.touch()
+	go ws.keepaliveLoop(interval, timeout)
+}
+
+func (ws *Conn) keepaliveLoop(interval, timeout time.Duration) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ws.done:
+			return
+		case <-timer.C:
+		}
+
+		if ws.pingSent.Load() != 0 {
+			// the pong of the last ping did not arrive in time.
+			ws.abort(&CloseError{Code: StatusAbnormalClosure, Reason: "pong timeout"}, StatusGoingAway)
+			return
+		}
+
+		idle := time.Since(time.Unix(0, ws.lastRead.Load()))
+		if idle < interval {
+			timer.Reset(interval - idle)
+			continue
+		}
+
+		// The ping carries the time it was sent at, which is echoed back
+		// in the pong, RFC 6455 (Section 5.5.3).
+		now := time.Now().UnixNano()
+		var p [8]byte
+		binary.BigEndian.PutUint64(p[:], uint64(now))
+
+		ws.pingSent.Store(now)
+		if err := ws.writeControl(PingMsg, p[:]); err != nil {
+			return
+		}
+
+		timer.Reset(timeout)
+	}
+}
+
+// touch records the time of the latest read from the connection.
+func (ws *Conn) touch() {
+	if ws.keepalive {
+		ws.lastRead.Store(time.Now().UnixNano())
+	}
+}
+
+// keepalivePong measures the round-trip time if p is the pong of the
+// last keepalive ping.
+func (ws *Conn) keepalivePong(p []byte) {
+	sent := ws.pingSent.Load()
+	if sent == 0 || len(p) != 8 || int64(binary.BigEndian.Uint64(p)) != sent {
+		return
+	}
+
+	ws.rtt.Store(time.Now().UnixNano() - sent)
+	ws.pingSent.Store(0)
+}
+
+// RTT returns the round-trip time measured by the latest keepalive ping,
+// or zero if no pong was received yet.
+func (ws *Conn) RTT() time.Duration {
+	return time.Duration(ws.rtt.Load())
+}
+
+// abort fails the connection from outside the reader, it sends a close
+// frame with code and closes the connection. Pending and later reads
+// return err.
+func (ws *Conn) abort(err *CloseError, code int) {
+	ws.abortErr.Store(err)
+
+	// bound the time a pending writer can hold the close frame back.
+	ws.conn.SetWriteDeadline(time.Now().Add(ws.closeTimeout))
+	if p, err := formatCloseMessage(code, err.Reason); err == nil {
+		ws.writeControl(CloseMsg, p)
+	}
+
+	ws.Close()
+}
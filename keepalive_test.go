@@ -0,0 +1,108 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestKeepaliveTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	c := newConn(a, false, nil, nil)
+	defer c.Close()
+	c.startKeepalive(20*time.Millisecond, 20*time.Millisecond)
+
+	// the peer reads the frames, but does not answer the ping.
+	frames := make(chan *Frame, 4)
+	go func() {
+		defer close(frames)
+		for {
+			f, err := ReadFrame(b, true)
+			if err != nil {
+				return
+			}
+			frames <- f
+		}
+	}()
+
+	// locally the connection failed without a closing handshake.
+	var ce *CloseError
+	if _, _, err := c.RecvMsg(); !errors.As(err, &ce) || ce.Code != StatusAbnormalClosure {
+		t.Fatalf("got %v, want a close error with status %d", err, StatusAbnormalClosure)
+	}
+
+	if f := <-frames; f == nil || f.Opcode != PingMsg || len(f.Payload) != 8 {
+		t.Fatalf("got frame %+v, want a ping carrying its time", f)
+	}
+
+	// the peer is told that the server is going away.
+	if f := <-frames; f == nil || f.Opcode != CloseMsg || binary.BigEndian.Uint16(f.Payload) != StatusGoingAway {
+		t.Fatalf("got frame %+v, want a close frame with status %d", f, StatusGoingAway)
+	}
+}
+
+func TestKeepaliveRTT(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	c := newConn(a, false, nil, nil)
+	defer c.Close()
+	c.startKeepalive(10*time.Millisecond, time.Second)
+
+	if c.RTT() != 0 {
+		t.Fatal("RTT is set before a pong was received")
+	}
+
+	// the peer answers the pings after a delay.
+	const delay = 20 * time.Millisecond
+	go func() {
+		for {
+			f, err := ReadFrame(b, true)
+			if err != nil {
+				return
+			}
+
+			if f.Opcode == PingMsg {
+				time.Sleep(delay)
+				if WriteFrame(b, true, &Frame{Fin: true, Opcode: PongMsg, Payload: f.Payload}) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	// the pongs are received while reading.
+	received := make(chan error, 1)
+	go func() {
+		_, _, err := c.RecvMsg()
+		received <- err
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.RTT() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("RTT was not measured")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if rtt := c.RTT(); rtt < delay || rtt > time.Second {
+		t.Fatalf("got RTT %v, want at least %v", rtt, delay)
+	}
+
+	// the answered pings keep the connection open.
+	time.Sleep(5 * delay)
+	select {
+	case err := <-received:
+		t.Fatalf("the connection failed: %v", err)
+	default:
+	}
+}
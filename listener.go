@@ -0,0 +1,138 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errHeaderTooLarge   = errors.New("bisoc: opening handshake too large")
+	errHandshakeTimeout = errors.New("bisoc: opening handshake timeout")
+)
+
+// Listener returns a listener which bounds the opening handshake of its
+// connections, so that clients which connect and then stall can not hold on
+// to them: a connection is closed unless its handshake is accepted by the
+// server within HandShakeTimeout, and reading fails once more than
+// MaxHeaderBytes were read before that.
+//
+// The limits apply to every connection of the listener, so it is meant for
+// an [http.Server] serving WebSocket upgrades over HTTP/1.1 only. A TLS
+// listener must wrap the returned listener, the TLS handshake then counts
+// towards MaxHeaderBytes.
+func (wss *Server) Listener(ln net.Listener) net.Listener {
+	return &handshakeListener{Listener: ln, wss: wss}
+}
+
+type handshakeListener struct {
+	net.Listener
+	wss *Server
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return l.wss.limitHandshake(conn), nil
+}
+
+// limitHandshake bounds the opening handshake of conn by HandShakeTimeout and
+// MaxHeaderBytes.
+func (wss *Server) limitHandshake(conn net.Conn) *handshakeConn {
+	max := wss.MaxHeaderBytes
+	if max <= 0 {
+		max = MaxHeaderBytes
+	}
+
+	c := &handshakeConn{Conn: conn}
+	c.remain.Store(int64(max))
+	if d := wss.HandShakeTimeout; d > 0 {
+		c.timer = time.AfterFunc(d, c.expire)
+	}
+
+	return c
+}
+
+// handshakeConn is a connection of a handshakeListener.
+type handshakeConn struct {
+	net.Conn
+	state  atomic.Int32 // handshakePending, handshakeDone or handshakeExpired
+	remain atomic.Int64 // bytes which can be read until the handshake is done
+	timer  *time.Timer
+}
+
+const (
+	handshakePending = iota
+	handshakeDone
+	handshakeExpired
+)
+
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	if c.state.Load() == handshakeDone {
+		return c.Conn.Read(p)
+	}
+
+	remain := c.remain.Load()
+	if remain <= 0 {
+		return 0, errHeaderTooLarge
+	}
+
+	if int64(len(p)) > remain {
+		p = p[:remain]
+	}
+
+	n, err := c.Conn.Read(p)
+	c.remain.Add(-int64(n))
+	return n, err
+}
+
+// expire closes the connection if its handshake is not done.
+func (c *handshakeConn) expire() {
+	if c.state.CompareAndSwap(handshakePending, handshakeExpired) {
+		c.Conn.Close()
+	}
+}
+
+// done lifts the limits of the connection, it reports false if the
+// connection was closed by the timeout.
+func (c *handshakeConn) done() bool {
+	if c.state.CompareAndSwap(handshakePending, handshakeDone) {
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+
+		return true
+	}
+
+	return c.state.Load() == handshakeDone
+}
+
+// handshakeCompleted lifts the limits of the connections of handshakeListener
+// and AcceptConn wrapped by conn, it reports false if a connection was closed
+// by its timeout.
+func handshakeCompleted(conn net.Conn) bool {
+	for {
+		if tc, ok := conn.(*tls.Conn); ok {
+			conn = tc.NetConn()
+		}
+
+		hc, ok := conn.(*handshakeConn)
+		if !ok {
+			return true
+		}
+
+		if !hc.done() {
+			return false
+		}
+		conn = hc.Conn
+	}
+}
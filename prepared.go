@@ -0,0 +1,122 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import "sync"
+
+// PreparedMessage is a data message which is encoded once and then sent to
+// many connections with [Conn.WritePreparedMessage].
+//
+// The encoded frame is cached for every compression setting it is sent with,
+// so it is only compressed once per setting. Frames can not be shared by
+// client connections, which mask every frame with a new key, and by
+// connections compressing with context takeover, whose compressed output
+// depends on the previous messages. For those the message is written as if
+// it was sent with [Conn.SendMsg].
+type PreparedMessage struct {
+	msgKind int
+	data    string
+
+	mu     sync.Mutex
+	frames map[preparedKey][]byte
+}
+
+// preparedKey identifies the encoding of a prepared frame.
+type preparedKey struct {
+	compressed bool
+	level      int
+}
+
+// NewPreparedMessage returns a prepared message, msgKind must be [TextMsg]
+// or [BinMsg].
+func NewPreparedMessage(msgKind int, data string) (*PreparedMessage, error) {
+	if !isDataFrame(msgKind) {
+		return nil, errInvalidMsgKind
+	}
+
+	return &PreparedMessage{
+		msgKind: msgKind,
+		data:    data,
+		frames:  make(map[preparedKey][]byte),
+	}, nil
+}
+
+// frame returns the encoded server frame of the message for key.
+func (pm *PreparedMessage) frame(key preparedKey) ([]byte, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if b, ok := pm.frames[key]; ok {
+		return b, nil
+	}
+
+	var (
+		payload = []byte(pm.data)
+		rsv     byte
+	)
+
+	if key.compressed {
+		var err error
+		payload, err = compressMessage(pm.data, key.level)
+		if err != nil {
+			return nil, err
+		}
+
+		rsv = RSV1
+	}
+
+	// the whole message is sent as a single unmasked frame.
+	var header [maxFrameHeaderSize]byte
+	n := putHeader(header[:], pm.msgKind, rsv, true, len(payload), false)
+
+	b := make([]byte, n+len(payload))
+	copy(b, header[:n])
+	copy(b[n:], payload)
+
+	pm.frames[key] = b
+	return b, nil
+}
+
+// WritePreparedMessage sends a prepared message to the connected peer.
+func (ws *Conn) WritePreparedMessage(pm *PreparedMessage) error {
+	key, ok := ws.preparedKey()
+	if !ok {
+		return ws.SendMsg(pm.msgKind, pm.data)
+	}
+
+	b, err := pm.frame(key)
+	if err != nil {
+		return err
+	}
+
+	if err := ws.writeState(); err != nil {
+		return err
+	}
+
+	// the frame must not be written between the fragments of another message.
+	ws.msgLock <- struct{}{}
+	defer func() { <-ws.msgLock }()
+
+	return ws.writeRaw(b, false)
+}
+
+// preparedKey returns the key of the prepared frames the connection can send,
+// ok is false if the connection can not share frames with other connections.
+func (ws *Conn) preparedKey() (key preparedKey, ok bool) {
+	if ws.client {
+		return key, false
+	}
+
+	switch len(ws.extensions) {
+	case 0:
+		return key, true
+	case 1:
+		if dc, isDeflate := ws.extensions[0].(*deflateConn); isDeflate && dc.writeNoContextTakeover {
+			return preparedKey{compressed: true, level: dc.level}, true
+		}
+	}
+
+	return key, false
+}
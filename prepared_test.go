@@ -0,0 +1,82 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"compress/flate"
+	"net"
+	"testing"
+)
+
+func TestPreparedMessageFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  bool
+		deflate *PerMessageDeflate // compression of the server, nil for none
+		key     preparedKey        // key of the frame sent, if it is shared
+		frames  int                // number of frames prepared so far
+	}{
+		{"plain", false, nil, preparedKey{}, 1},
+		{"no context takeover", false, &PerMessageDeflate{ServerNoContextTakeover: true}, preparedKey{compressed: true, level: flate.DefaultCompression}, 2},
+		{"best speed", false, &PerMessageDeflate{ServerNoContextTakeover: true, Level: flate.BestSpeed}, preparedKey{compressed: true, level: flate.BestSpeed}, 3},
+		// these connections can not share frames.
+		{"context takeover", false, &PerMessageDeflate{}, preparedKey{compressed: true, level: flate.DefaultCompression}, 3},
+		{"client", true, nil, preparedKey{}, 3},
+	}
+
+	pm, err := NewPreparedMessage(TextMsg, "hello hello hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			c := newConn(a, tt.client, nil, nil)
+			peer := newConn(b, !tt.client, nil, nil)
+			defer c.Close()
+			defer peer.Close()
+
+			if tt.deflate != nil {
+				st, ct := deflatePair(t, tt.deflate, &PerMessageDeflate{})
+				c.setExtensions([]ExtensionTransform{st})
+				peer.setExtensions([]ExtensionTransform{ct})
+			}
+
+			for range 2 {
+				received := make(chan string, 1)
+				go func() {
+					_, p, _ := peer.RecvMsg()
+					received <- string(p)
+				}()
+
+				if err := c.WritePreparedMessage(pm); err != nil {
+					t.Fatal(err)
+				}
+
+				if p := <-received; p != pm.data {
+					t.Fatalf("the peer received %q, want %q", p, pm.data)
+				}
+			}
+
+			// the frame is encoded once per key, and only if it can be shared.
+			if _, ok := pm.frames[tt.key]; !ok {
+				t.Fatalf("no frame prepared for %+v", tt.key)
+			}
+
+			if len(pm.frames) != tt.frames {
+				t.Fatalf("%d frames prepared, want %d", len(pm.frames), tt.frames)
+			}
+		})
+	}
+}
+
+func TestNewPreparedMessage(t *testing.T) {
+	for _, kind := range []int{PingMsg, CloseMsg, continuation} {
+		if _, err := NewPreparedMessage(kind, ""); err == nil {
+			t.Errorf("a prepared message of kind %d was created", kind)
+		}
+	}
+}
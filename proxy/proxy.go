@@ -0,0 +1,359 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+// Package proxy implements a WebSocket reverse proxy on top of bisoc.
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/udaycmd/bisoc"
+)
+
+// Handler is an [http.Handler] which proxies WebSocket connections to a
+// backend.
+//
+// For every valid opening handshake the backend is dialed first, with the
+// subprotocols offered by the client, so that the client is accepted with the
+// subprotocol selected by the backend and the end-to-end headers of its
+// response, e.g. its cookies. Then the messages are relayed in both
+// directions.
+// Pings are forwarded to the other side and answered by the pongs of the
+// other side, close frames are forwarded with their status code and reason.
+type Handler struct {
+	// Backend returns the url of the backend for a request, e.g. one
+	// returned by RoundRobin or ByHeader. The path and query of the request
+	// are appended to the path of the url.
+	Backend func(r *http.Request) (*url.URL, error)
+
+	// Dialer is used to connect to the backend. If nil, bisoc.DefaultDialer
+	// is used.
+	Dialer *bisoc.Dialer
+
+	// Rewrite modifies the headers of the backend's opening handshake, which
+	// hold the end-to-end headers of the request and the X-Forwarded-For,
+	// X-Forwarded-Host and X-Forwarded-Proto headers. The Host header is the
+	// host of the request, so that a backend checking the Origin header as
+	// bisoc.Server does accepts the clients accepted by CheckOrigin, it can
+	// be set to the host of the backend.
+	Rewrite func(r *http.Request, header http.Header)
+
+	// HandShakeTimeout, CheckOrigin and Compression configure the server
+	// accepting the client, see bisoc.Server.
+	HandShakeTimeout time.Duration
+	CheckOrigin      func(r *http.Request) bool
+	Compression      *bisoc.PerMessageDeflate
+
+	// ErrorLog logs the errors of proxied connections. If nil, errors are
+	// not logged.
+	ErrorLog *log.Logger
+}
+
+// ServeHTTP proxies the WebSocket connection of r to the backend.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv := &bisoc.Server{
+		HandShakeTimeout: h.HandShakeTimeout,
+		CheckOrigin:      h.CheckOrigin,
+		Compression:      h.Compression,
+	}
+
+	// the backend is only dialed for a valid opening handshake, Accept
+	// writes the rejection of the client.
+	if err := srv.CheckRequest(r); err != nil {
+		srv.Accept(w, r, nil)
+		h.logf("proxy: accept: %v", err)
+		return
+	}
+
+	target, err := h.Backend(r)
+	if err != nil {
+		h.logf("proxy: no backend for %s: %v", r.URL, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	d := bisoc.DefaultDialer
+	if h.Dialer != nil {
+		d = h.Dialer
+	}
+
+	dialer := *d
+	dialer.Subprotocols = subprotocols(r.Header)
+
+	backend, resp, err := dialer.DialContext(r.Context(), joinURL(target, r.URL).String(), h.backendHeader(r))
+	if err != nil {
+		h.logf("proxy: dial %s: %v", target, err)
+
+		// pass a refusal of the backend on to the client.
+		code := http.StatusBadGateway
+		if resp != nil && resp.StatusCode >= http.StatusBadRequest {
+			code = resp.StatusCode
+		}
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	defer backend.Close()
+
+	if p := backend.Subprotocol(); p != "" {
+		srv.Subprotocols = []string{p}
+	}
+
+	client, err := srv.Accept(w, r, clientHeader(resp.Header))
+	if err != nil {
+		h.logf("proxy: accept: %v", err)
+		backend.CloseWithCode(bisoc.StatusGoingAway, "")
+		return
+	}
+	defer client.Close()
+
+	relay(client, backend)
+}
+
+// relay copies the messages between a and b until both are closed.
+func relay(a, b *bisoc.Conn) {
+	forwardControl(a, b)
+	forwardControl(b, a)
+
+	done := make(chan struct{})
+	go func() {
+		copyMessages(a, b)
+		close(done)
+	}()
+	copyMessages(b, a)
+	<-done
+}
+
+// forwardControl forwards the pings received on src to dst, and the pongs
+// received on dst back to src. The close frames received on src are not
+// answered, they are forwarded by copyMessages.
+func forwardControl(src, dst *bisoc.Conn) {
+	src.OnPing(func(appData string) error {
+		if err := dst.SendMsg(bisoc.PingMsg, appData); err != nil && err != bisoc.ErrCloseSent {
+			return err
+		}
+
+		return nil
+	})
+
+	dst.OnPong(func(appData string) error {
+		if err := src.SendMsg(bisoc.PongMsg, appData); err != nil && err != bisoc.ErrCloseSent {
+			return err
+		}
+
+		return nil
+	})
+
+	src.OnClose(func(int, string) error {
+		return nil
+	})
+}
+
+// copyMessages copies the messages read from src to dst. Once reading from
+// src fails, its close frame is forwarded to dst, or dst is closed with
+// bisoc.StatusGoingAway if src was not closed by the peer.
+func copyMessages(dst, src *bisoc.Conn) {
+	for {
+		kind, r, err := src.NextReader()
+		if err != nil {
+			closeWith(dst, err)
+			return
+		}
+
+		w, err := dst.NextWriter(kind)
+		if err != nil {
+			src.CloseWithCode(bisoc.StatusGoingAway, "")
+			return
+		}
+
+		er := &errReader{r: r}
+		_, err = io.Copy(w, er)
+		if er.err != nil {
+			// reading the message failed, dst is closed before the partial
+			// message could be completed.
+			closeWith(dst, er.err)
+			w.Close()
+			return
+		}
+
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			src.CloseWithCode(bisoc.StatusGoingAway, "")
+			return
+		}
+	}
+}
+
+// errReader records the error of reading a message.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (er *errReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err != nil && err != io.EOF {
+		er.err = err
+	}
+
+	return n, err
+}
+
+// closeWith closes dst with the close frame of err.
+func closeWith(dst *bisoc.Conn, err error) {
+	var ce *bisoc.CloseError
+	if errors.As(err, &ce) {
+		code := ce.Code
+		if code == 0 {
+			code = bisoc.StatusNoStatusReceived
+		}
+
+		if dst.CloseWithCode(code, ce.Reason) == nil {
+			return
+		}
+	}
+
+	dst.CloseWithCode(bisoc.StatusGoingAway, "")
+}
+
+// hopHeaders are not forwarded between the client and the backend, the
+// WebSocket headers are set by the dialer and the server.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Accept",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Extensions",
+	"Sec-Websocket-Protocol",
+	":protocol", // pseudo-header field of an HTTP/2 extended CONNECT request
+}
+
+// backendHeader returns the headers of the backend's opening handshake.
+func (h *Handler) backendHeader(r *http.Request) http.Header {
+	header := r.Header.Clone()
+	for _, k := range hopHeaders {
+		header.Del(k)
+	}
+
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := header["X-Forwarded-For"]; len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		header.Set("X-Forwarded-For", ip)
+	}
+
+	header.Set("Host", r.Host)
+	header.Set("X-Forwarded-Host", r.Host)
+	if r.TLS != nil {
+		header.Set("X-Forwarded-Proto", "https")
+	} else {
+		header.Set("X-Forwarded-Proto", "http")
+	}
+
+	if h.Rewrite != nil {
+		h.Rewrite(r, header)
+	}
+
+	return header
+}
+
+// clientHeader returns the headers of the backend's response which are sent
+// to the client, e.g. its cookies.
+func clientHeader(backend http.Header) http.Header {
+	header := backend.Clone()
+	for _, k := range hopHeaders {
+		header.Del(k)
+	}
+	header.Del("Content-Length")
+
+	// the server rejects every Sec-WebSocket-* header of the response.
+	for k := range header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(k), "Sec-Websocket-") {
+			delete(header, k)
+		}
+	}
+
+	return header
+}
+
+func (h *Handler) logf(format string, args ...any) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+	}
+}
+
+// RoundRobin returns a Backend function which selects the backends in turn.
+func RoundRobin(backends ...*url.URL) func(r *http.Request) (*url.URL, error) {
+	var next atomic.Uint64
+	return func(_ *http.Request) (*url.URL, error) {
+		if len(backends) == 0 {
+			return nil, errors.New("proxy: no backends")
+		}
+
+		i := next.Add(1) - 1
+		return backends[i%uint64(len(backends))], nil
+	}
+}
+
+// ByHeader returns a Backend function which selects the backend by the value
+// of the request header name, fallback is used for other values. If fallback
+// is nil, these requests are rejected.
+func ByHeader(name string, backends map[string]*url.URL, fallback *url.URL) func(r *http.Request) (*url.URL, error) {
+	return func(r *http.Request) (*url.URL, error) {
+		if u, ok := backends[r.Header.Get(name)]; ok {
+			return u, nil
+		}
+
+		if fallback == nil {
+			return nil, errors.New("proxy: no backend for " + name + " " + r.Header.Get(name))
+		}
+
+		return fallback, nil
+	}
+}
+
+// joinURL appends the path and query of the request url to the backend url.
+func joinURL(backend, req *url.URL) *url.URL {
+	u := *backend
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(req.Path, "/")
+	if u.RawQuery == "" || req.RawQuery == "" {
+		u.RawQuery += req.RawQuery
+	} else {
+		u.RawQuery += "&" + req.RawQuery
+	}
+
+	return &u
+}
+
+// subprotocols returns the subprotocols offered by the client.
+func subprotocols(h http.Header) []string {
+	var protocols []string
+	for _, v := range h.Values("Sec-Websocket-Protocol") {
+		for p := range strings.SplitSeq(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+
+	return protocols
+}
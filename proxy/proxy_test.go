@@ -0,0 +1,338 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/udaycmd/bisoc"
+)
+
+// newBackend starts a server accepting WebSocket connections with wss, the
+// handler is called with every request and accepted connection.
+func newBackend(t *testing.T, wss *bisoc.Server, handler func(r *http.Request, c *bisoc.Conn)) *url.URL {
+	t.Helper()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := wss.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		handler(r, c)
+	}))
+	t.Cleanup(s.Close)
+
+	u, _ := url.Parse("ws" + strings.TrimPrefix(s.URL, "http"))
+	return u
+}
+
+// newFront starts a server proxying with h and returns its ws:// url.
+func newFront(t *testing.T, h *Handler) string {
+	t.Helper()
+
+	s := httptest.NewServer(h)
+	t.Cleanup(s.Close)
+
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// echo sends every message received on c back to the peer.
+func echo(_ *http.Request, c *bisoc.Conn) {
+	for {
+		kind, p, err := c.RecvMsg()
+		if err != nil {
+			return
+		}
+
+		if err := c.SendMsg(kind, string(p)); err != nil {
+			return
+		}
+	}
+}
+
+// to returns a Backend function which always selects u.
+func to(u *url.URL) func(*http.Request) (*url.URL, error) {
+	return func(*http.Request) (*url.URL, error) { return u, nil }
+}
+
+func TestHandlerChecksBeforeDial(t *testing.T) {
+	var dialed atomic.Int32
+	backend := newBackend(t, &bisoc.Server{}, func(r *http.Request, c *bisoc.Conn) {
+		dialed.Add(1)
+		echo(r, c)
+	})
+
+	front := httptest.NewServer(&Handler{Backend: to(backend)})
+	defer front.Close()
+
+	upgrade := http.Header{
+		"Upgrade":               {"websocket"},
+		"Connection":            {"Upgrade"},
+		"Sec-Websocket-Version": {"13"},
+		"Sec-Websocket-Key":     {"dGhlIHNhbXBsZSBub25jZQ=="},
+	}
+
+	tests := []struct {
+		name   string
+		header func(h http.Header)
+		status int
+	}{
+		{"plain request", func(h http.Header) { clear(h) }, http.StatusBadRequest},
+		{"bad key", func(h http.Header) { h.Set("Sec-Websocket-Key", "short") }, http.StatusBadRequest},
+		{"foreign origin", func(h http.Header) { h.Set("Origin", "http://example.com") }, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, front.URL, nil)
+			req.Header = upgrade.Clone()
+			tt.header(req.Header)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+
+	if n := dialed.Load(); n != 0 {
+		t.Fatalf("the backend was dialed %d times for rejected requests", n)
+	}
+
+	roundTrip(t, "ws"+strings.TrimPrefix(front.URL, "http"), nil)
+
+	if n := dialed.Load(); n != 1 {
+		t.Fatalf("the backend was dialed %d times, want 1", n)
+	}
+}
+
+// roundTrip dials u with header and checks that messages are echoed.
+func roundTrip(t *testing.T, u string, header http.Header) {
+	t.Helper()
+
+	c, _, err := bisoc.DefaultDialer.Dial(u, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, m := range []struct {
+		kind int
+		data string
+	}{
+		{bisoc.TextMsg, "hello"},
+		{bisoc.BinMsg, strings.Repeat("\x00\xff", 64<<10)},
+	} {
+		if err := c.SendMsg(m.kind, m.data); err != nil {
+			t.Fatal(err)
+		}
+
+		kind, p, err := c.RecvMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if kind != m.kind || string(p) != m.data {
+			t.Fatalf("got a message of kind %d and %d bytes, want kind %d and %d bytes", kind, len(p), m.kind, len(m.data))
+		}
+	}
+}
+
+func TestHandlerRelay(t *testing.T) {
+	backend := newBackend(t, &bisoc.Server{}, echo)
+	roundTrip(t, newFront(t, &Handler{Backend: to(backend)}), nil)
+}
+
+func TestHandlerOrigin(t *testing.T) {
+	// the backend checks the origin against the host of the proxy.
+	backend := newBackend(t, &bisoc.Server{}, echo)
+	u := newFront(t, &Handler{Backend: to(backend)})
+
+	origin := "http" + strings.TrimPrefix(u, "ws")
+	roundTrip(t, u, http.Header{"Origin": {origin}})
+}
+
+func TestHandlerClose(t *testing.T) {
+	fromClient := make(chan error, 1)
+	backend := newBackend(t, &bisoc.Server{}, func(_ *http.Request, c *bisoc.Conn) {
+		kind, p, err := c.RecvMsg()
+		if err != nil {
+			fromClient <- err
+			return
+		}
+
+		if kind == bisoc.TextMsg && string(p) == "close" {
+			c.CloseWithCode(4001, "bye")
+			return
+		}
+
+		_, _, err = c.RecvMsg()
+		fromClient <- err
+	})
+	u := newFront(t, &Handler{Backend: to(backend)})
+
+	t.Run("from backend", func(t *testing.T) {
+		c, _, err := bisoc.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		if err := c.SendMsg(bisoc.TextMsg, "close"); err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = c.RecvMsg()
+		var ce *bisoc.CloseError
+		if !errors.As(err, &ce) || ce.Code != 4001 || ce.Reason != "bye" {
+			t.Fatalf("got %v, want the close frame 4001 of the backend", err)
+		}
+	})
+
+	t.Run("from client", func(t *testing.T) {
+		c, _, err := bisoc.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		if err := c.SendMsg(bisoc.TextMsg, "stay"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.CloseWithCode(4002, "done"); err != nil {
+			t.Fatal(err)
+		}
+
+		var ce *bisoc.CloseError
+		if err := <-fromClient; !errors.As(err, &ce) || ce.Code != 4002 || ce.Reason != "done" {
+			t.Fatalf("got %v, want the close frame 4002 of the client", err)
+		}
+	})
+}
+
+func TestHandlerSubprotocol(t *testing.T) {
+	offered := make(chan []string, 1)
+	wss := &bisoc.Server{Subprotocols: []string{"b"}}
+	backend := newBackend(t, wss, func(r *http.Request, c *bisoc.Conn) {
+		offered <- r.Header.Values("Sec-Websocket-Protocol")
+	})
+
+	d := &bisoc.Dialer{Subprotocols: []string{"a", "b"}}
+	c, _, err := d.Dial(newFront(t, &Handler{Backend: to(backend)}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if p := c.Subprotocol(); p != "b" {
+		t.Fatalf("got subprotocol %q, want the choice of the backend b", p)
+	}
+
+	if got := strings.Join(<-offered, ","); strings.ReplaceAll(got, " ", "") != "a,b" {
+		t.Fatalf("the backend was offered %q, want a, b", got)
+	}
+}
+
+func TestHandlerForwardedHeaders(t *testing.T) {
+	headers := make(chan *http.Request, 1)
+	backend := newBackend(t, &bisoc.Server{}, func(r *http.Request, c *bisoc.Conn) {
+		headers <- r
+	})
+
+	u := newFront(t, &Handler{
+		Backend: to(backend),
+		Rewrite: func(r *http.Request, header http.Header) {
+			header.Set("X-Rewritten", r.URL.Path)
+		},
+	})
+
+	c, _, err := bisoc.DefaultDialer.Dial(u+"/chat?room=1", http.Header{
+		"X-Forwarded-For": {"10.0.0.1"},
+		"Cookie":          {"session=1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	r := <-headers
+	host := strings.TrimPrefix(u, "ws://")
+	want := map[string]string{
+		"X-Forwarded-For":   "10.0.0.1, 127.0.0.1",
+		"X-Forwarded-Host":  host,
+		"X-Forwarded-Proto": "http",
+		"X-Rewritten":       "/chat",
+		"Cookie":            "session=1",
+	}
+
+	for k, v := range want {
+		if got := r.Header.Get(k); got != v {
+			t.Errorf("%s: got %q, want %q", k, got, v)
+		}
+	}
+
+	if r.Host != host {
+		t.Errorf("Host: got %q, want %q", r.Host, host)
+	}
+
+	if r.URL.Path != "/chat" || r.URL.RawQuery != "room=1" {
+		t.Errorf("got %s, want the path and query of the request", r.URL)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, _ := url.Parse("ws://a")
+	b, _ := url.Parse("ws://b")
+
+	next := RoundRobin(a, b)
+	for i, want := range []*url.URL{a, b, a, b} {
+		if u, err := next(nil); err != nil || u != want {
+			t.Fatalf("%d: got %v, %v, want %v", i, u, err, want)
+		}
+	}
+
+	if _, err := RoundRobin()(nil); err == nil {
+		t.Fatal("RoundRobin without backends selected a backend")
+	}
+}
+
+func TestByHeader(t *testing.T) {
+	a, _ := url.Parse("ws://a")
+	fallback, _ := url.Parse("ws://fallback")
+	backends := map[string]*url.URL{"a": a}
+
+	tests := []struct {
+		value    string
+		fallback *url.URL
+		want     *url.URL
+	}{
+		{"a", nil, a},
+		{"b", fallback, fallback},
+		{"", fallback, fallback},
+		{"b", nil, nil},
+	}
+
+	for _, tt := range tests {
+		r := &http.Request{Header: http.Header{"X-Tenant": {tt.value}}}
+		u, err := ByHeader("X-Tenant", backends, tt.fallback)(r)
+		if u != tt.want || (err == nil) != (tt.want != nil) {
+			t.Errorf("%q: got %v, %v, want %v", tt.value, u, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"sync"
+	"time"
+)
+
+// Broker delivers the messages published to a room to the subscribers of
+// the room, possibly across processes. [LocalBroker] is an in-process
+// implementation.
+type Broker interface {
+	// Publish sends a message to all subscribers of room.
+	Publish(room string, msgKind int, data string) error
+
+	// Subscribe calls handler for every message published to room until
+	// unsubscribe is called. The handler must not block.
+	Subscribe(room string, handler func(msgKind int, data string)) (unsubscribe func(), err error)
+}
+
+// LocalBroker is a [Broker] which delivers messages within the process.
+//
+// The zero value is a broker ready to use.
+type LocalBroker struct {
+	mu    sync.RWMutex
+	rooms map[string]map[*localSubscriber]struct{}
+}
+
+type localSubscriber struct {
+	handler func(msgKind int, data string)
+}
+
+// Publish calls the handlers of all subscribers of room.
+func (lb *LocalBroker) Publish(room string, msgKind int, data string) error {
+	if !isDataFrame(msgKind) {
+		return errInvalidMsgKind
+	}
+
+	lb.mu.RLock()
+	subs := make([]*localSubscriber, 0, len(lb.rooms[room]))
+	for sub := range lb.rooms[room] {
+		subs = append(subs, sub)
+	}
+	lb.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.handler(msgKind, data)
+	}
+
+	return nil
+}
+
+// Subscribe adds handler to the subscribers of room.
+func (lb *LocalBroker) Subscribe(room string, handler func(msgKind int, data string)) (func(), error) {
+	sub := &localSubscriber{handler: handler}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if lb.rooms == nil {
+		lb.rooms = make(map[string]map[*localSubscriber]struct{})
+	}
+
+	if lb.rooms[room] == nil {
+		lb.rooms[room] = make(map[*localSubscriber]struct{})
+	}
+	lb.rooms[room][sub] = struct{}{}
+
+	return func() {
+		lb.mu.Lock()
+		defer lb.mu.Unlock()
+
+		delete(lb.rooms[room], sub)
+		if len(lb.rooms[room]) == 0 {
+			delete(lb.rooms, room)
+		}
+	}, nil
+}
+
+// PubSub lets connections join and leave named rooms and sends the messages
+// published to a room to its members.
+//
+// Every room is subscribed to the [Broker] while it has members, the messages
+// of a room are sent to its members through a [Hub], so a slow member is
+// evicted from all of its rooms. A connection leaves all of its rooms once it
+// is closed or reading from it fails, e.g. when [Conn.RecvMsg] returns a
+// [*CloseError].
+//
+// The zero value is a PubSub with a [LocalBroker] ready to use, its fields
+// must not be changed once a connection joined a room.
+type PubSub struct {
+	// Broker delivers the published messages, if nil an in-process
+	// LocalBroker is used.
+	Broker Broker
+
+	// QueueSize and WriteTimeout configure the Hub of every room.
+	QueueSize    int
+	WriteTimeout time.Duration
+
+	mu      sync.Mutex
+	local   LocalBroker
+	rooms   map[string]*room
+	members map[*Conn]*member
+}
+
+// room is a room with members on this PubSub.
+type room struct {
+	hub         Hub
+	members     int
+	unsubscribe func()
+}
+
+// member holds the rooms joined by a connection.
+type member struct {
+	rooms map[string]struct{}
+	stop  chan struct{} // closed once the connection left all rooms
+}
+
+func (ps *PubSub) broker() Broker {
+	if ps.Broker != nil {
+		return ps.Broker
+	}
+
+	return &ps.local
+}
+
+// Join adds c to the room name, it does nothing if c is already a member.
+func (ps *PubSub) Join(c *Conn, name string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	m := ps.members[c]
+	if m != nil {
+		if _, ok := m.rooms[name]; ok {
+			return nil
+		}
+	}
+
+	r := ps.rooms[name]
+	if r == nil {
+		r = &room{hub: Hub{QueueSize: ps.QueueSize, WriteTimeout: ps.WriteTimeout}}
+
+		unsubscribe, err := ps.broker().Subscribe(name, func(msgKind int, data string) {
+			pm, err := NewPreparedMessage(msgKind, data)
+			if err != nil {
+				return
+			}
+
+			r.hub.Broadcast(pm)
+		})
+		if err != nil {
+			return err
+		}
+		r.unsubscribe = unsubscribe
+
+		if ps.rooms == nil {
+			ps.rooms = make(map[string]*room)
+		}
+		ps.rooms[name] = r
+	}
+
+	if m == nil {
+		m = &member{
+			rooms: make(map[string]struct{}),
+			stop:  make(chan struct{}),
+		}
+
+		if ps.members == nil {
+			ps.members = make(map[*Conn]*member)
+		}
+		ps.members[c] = m
+
+		go ps.watch(c, m)
+	}
+
+	m.rooms[name] = struct{}{}
+	r.members++
+	r.hub.Register(c)
+
+	return nil
+}
+
+// Leave removes c from the room name.
+func (ps *PubSub) Leave(c *Conn, name string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.leave(c, name)
+}
+
+// LeaveAll removes c from all of its rooms.
+func (ps *PubSub) LeaveAll(c *Conn) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.leaveAll(c)
+}
+
+// Publish sends a message to all members of the room name through the broker,
+// msgKind must be [TextMsg] or [BinMsg].
+func (ps *PubSub) Publish(name string, msgKind int, data string) error {
+	if !isDataFrame(msgKind) {
+		return errInvalidMsgKind
+	}
+
+	return ps.broker().Publish(name, msgKind, data)
+}
+
+// Rooms returns the rooms joined by c.
+func (ps *PubSub) Rooms(c *Conn) []string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	m := ps.members[c]
+	if m == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(m.rooms))
+	for name := range m.rooms {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// Members returns the number of connections of this PubSub in the room name.
+func (ps *PubSub) Members(name string) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if r := ps.rooms[name]; r != nil {
+		return r.members
+	}
+
+	return 0
+}
+
+// leave removes c from the room name, ps.mu must be held.
+func (ps *PubSub) leave(c *Conn, name string) {
+	m := ps.members[c]
+	if m == nil {
+		return
+	}
+
+	if _, ok := m.rooms[name]; !ok {
+		return
+	}
+
+	delete(m.rooms, name)
+	if len(m.rooms) == 0 {
+		delete(ps.members, c)
+		close(m.stop)
+	}
+
+	r := ps.rooms[name]
+	r.hub.Unregister(c)
+	r.members--
+	if r.members == 0 {
+		delete(ps.rooms, name)
+		r.unsubscribe()
+	}
+}
+
+// leaveAll removes c from all of its rooms, ps.mu must be held.
+func (ps *PubSub) leaveAll(c *Conn) {
+	m := ps.members[c]
+	if m == nil {
+		return
+	}
+
+	for name := range m.rooms {
+		ps.leave(c, name)
+	}
+}
+
+// watch removes c from all of its rooms once it is closed or reading from
+// it fails.
+func (ps *PubSub) watch(c *Conn, m *member) {
+	select {
+	case <-m.stop:
+		return
+	case <-c.done:
+	case <-c.readDone:
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.members[c] == m {
+		ps.leaveAll(c)
+	}
+}
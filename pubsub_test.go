@@ -0,0 +1,174 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalBroker(t *testing.T) {
+	var lb LocalBroker
+
+	got := make(map[string][]string)
+	subscribe := func(name, room string) func() {
+		unsubscribe, err := lb.Subscribe(room, func(_ int, data string) {
+			got[name] = append(got[name], data)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return unsubscribe
+	}
+
+	unsubscribeA := subscribe("a", "room")
+	unsubscribeB := subscribe("b", "room")
+	unsubscribeC := subscribe("c", "other")
+
+	lb.Publish("room", TextMsg, "1")
+	unsubscribeA()
+	lb.Publish("room", TextMsg, "2")
+	lb.Publish("nobody", TextMsg, "3")
+
+	want := map[string][]string{"a": {"1"}, "b": {"1", "2"}}
+	for name, msgs := range want {
+		if !slices.Equal(got[name], msgs) {
+			t.Errorf("%s got %q, want %q", name, got[name], msgs)
+		}
+	}
+
+	if len(got["c"]) != 0 {
+		t.Errorf("c got the messages %q of another room", got["c"])
+	}
+
+	if err := lb.Publish("room", PingMsg, ""); err == nil {
+		t.Error("a control message was published")
+	}
+
+	unsubscribeB()
+	unsubscribeC()
+	if len(lb.rooms) != 0 {
+		t.Errorf("%d rooms are left without subscribers", len(lb.rooms))
+	}
+}
+
+// waitMembers waits until the room name of ps has n members.
+func waitMembers(t *testing.T, ps *PubSub, name string, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ps.Members(name) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("room %s has %d members, want %d", name, ps.Members(name), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	var ps PubSub
+
+	// the server joins every connection to the rooms of its query.
+	accepted := make(chan *Conn, 4)
+	u := newTestServer(t, &Server{}, func(c *Conn) {
+		for _, name := range strings.Split(c.Handshake().URL.Query().Get("rooms"), ",") {
+			if err := ps.Join(c, name); err != nil {
+				t.Error(err)
+			}
+		}
+		accepted <- c
+
+		for {
+			if _, _, err := c.NextReader(); err != nil {
+				return
+			}
+		}
+	})
+
+	dial := func(rooms string) (*Conn, *Conn) {
+		c, _, err := DefaultDialer.Dial(u+"?rooms="+rooms, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+
+		return c, <-accepted
+	}
+
+	both, bothServer := dial("a,b")
+	onlyA, _ := dial("a")
+	onlyB, onlyBServer := dial("b")
+
+	if got := ps.Rooms(bothServer); len(got) != 2 || !slices.Contains(got, "a") || !slices.Contains(got, "b") {
+		t.Fatalf("got rooms %q, want a and b", got)
+	}
+
+	if ps.Members("a") != 2 || ps.Members("b") != 2 {
+		t.Fatalf("got %d and %d members, want 2 in both rooms", ps.Members("a"), ps.Members("b"))
+	}
+
+	if err := ps.Publish("a", TextMsg, "to a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish("b", TextMsg, "to b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rooms are written by their own hubs, so the messages of different
+	// rooms arrive in any order.
+	recv := func(c *Conn, want ...string) {
+		t.Helper()
+
+		var got []string
+		for range want {
+			_, p, err := c.RecvMsg()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(p))
+		}
+
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+	recv(both, "to a", "to b")
+	recv(onlyA, "to a")
+	recv(onlyB, "to b")
+
+	// a member which left a room gets no more messages of the room.
+	ps.Leave(bothServer, "a")
+	if got := ps.Rooms(bothServer); !slices.Equal(got, []string{"b"}) {
+		t.Fatalf("got rooms %q after leaving a, want b", got)
+	}
+
+	ps.Publish("a", TextMsg, "again to a")
+	ps.Publish("b", TextMsg, "again to b")
+	recv(both, "again to b")
+	recv(onlyA, "again to a")
+
+	// a member whose connection fails to read leaves its rooms.
+	onlyA.CloseWithCode(StatusNormalClosure, "")
+	waitMembers(t, &ps, "a", 0)
+	if _, ok := ps.rooms["a"]; ok {
+		t.Fatal("the room without members is still subscribed")
+	}
+
+	// a member whose connection is closed leaves its rooms.
+	onlyBServer.Close()
+	waitMembers(t, &ps, "b", 1)
+	if ps.Rooms(onlyBServer) != nil {
+		t.Fatal("a closed connection is still a member")
+	}
+
+	ps.LeaveAll(bothServer)
+	if ps.Members("b") != 0 || len(ps.members) != 0 {
+		t.Fatal("LeaveAll left a member in a room")
+	}
+}
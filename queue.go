@@ -0,0 +1,240 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var errQueueStarted = errors.New("bisoc: send queue is already in use")
+
+// QueuePolicy is the behaviour of [Conn.Enqueue] when the send queue of the
+// connection is full.
+type QueuePolicy int
+
+const (
+	// QueueBlock waits until there is space in the queue.
+	QueueBlock QueuePolicy = iota
+
+	// QueueDropOldest drops the oldest queued message to make space.
+	QueueDropOldest
+
+	// QueueDropNewest drops the message being enqueued.
+	QueueDropNewest
+
+	// QueueClose closes the connection with [StatusPolicyViolation].
+	QueueClose
+)
+
+// QueueStats are the metrics of the send queue of a connection.
+type QueueStats struct {
+	Len     int    // number of queued messages
+	Cap     int    // size of the queue
+	Sent    uint64 // number of messages written to the connection
+	Dropped uint64 // number of messages dropped by the queue policy
+}
+
+// sendQueue is the send queue of a connection, its messages are written by
+// a goroutine of their own.
+type sendQueue struct {
+	msgs    chan queuedMsg
+	policy  QueuePolicy
+	mu      sync.Mutex // held while making space for QueueDropOldest
+	err     atomic.Pointer[error]
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+type queuedMsg struct {
+	msgKind int
+	data    string
+	pm      *PreparedMessage
+}
+
+// SetSendQueue sets the size of the send queue and the policy applied when it
+// is full, it fails once the queue is in use after the first message was
+// enqueued. The default is a queue of [SendQueueSize] messages with
+// [QueueBlock].
+func (ws *Conn) SetSendQueue(size int, policy QueuePolicy) error {
+	ws.queueMu.Lock()
+	defer ws.queueMu.Unlock()
+
+	if ws.queue.Load() != nil {
+		return errQueueStarted
+	}
+
+	ws.queueSize = size
+	ws.queuePolicy = policy
+	return nil
+}
+
+// Enqueue queues a message to be sent to the connected peer without waiting
+// for it to be written, unless the queue is full and its policy is
+// [QueueBlock]. The messages are sent in order by a goroutine of the
+// connection, which is started on the first use of the queue.
+//
+// Once a queued message could not be written, the error is returned by all
+// later calls and the rest of the queue is dropped, a call which is racing
+// with the failed write returns the error as well.
+func (ws *Conn) Enqueue(msgKind int, data string) error {
+	if !isDataFrame(msgKind) {
+		return errInvalidMsgKind
+	}
+
+	return ws.enqueue(queuedMsg{msgKind: msgKind, data: data})
+}
+
+// EnqueuePrepared is like Enqueue for a prepared message.
+func (ws *Conn) EnqueuePrepared(pm *PreparedMessage) error {
+	return ws.enqueue(queuedMsg{pm: pm})
+}
+
+// QueueStats returns the metrics of the send queue, they are zero until the
+// first message is enqueued.
+func (ws *Conn) QueueStats() QueueStats {
+	q := ws.queue.Load()
+	if q == nil {
+		return QueueStats{}
+	}
+
+	return QueueStats{
+		Len:     len(q.msgs),
+		Cap:     cap(q.msgs),
+		Sent:    q.sent.Load(),
+		Dropped: q.dropped.Load(),
+	}
+}
+
+func (ws *Conn) enqueue(m queuedMsg) error {
+	q := ws.sendQueue()
+	if err := q.err.Load(); err != nil {
+		return *err
+	}
+
+	if err := ws.writeState(); err != nil {
+		return err
+	}
+
+	if err := ws.push(q, m); err != nil {
+		return err
+	}
+
+	// the message is dropped with the rest of the queue if a write failed
+	// meanwhile.
+	if err := q.err.Load(); err != nil {
+		return *err
+	}
+
+	return nil
+}
+
+// push adds m to the queue, applying the policy of the queue if it is full.
+func (ws *Conn) push(q *sendQueue, m queuedMsg) error {
+	select {
+	case q.msgs <- m:
+		return nil
+	default:
+	}
+
+	// the queue is full.
+	switch q.policy {
+	case QueueDropOldest:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		for {
+			select {
+			case q.msgs <- m:
+				return nil
+			default:
+			}
+
+			select {
+			case <-q.msgs:
+				q.dropped.Add(1)
+			default:
+			}
+		}
+	case QueueDropNewest:
+		q.dropped.Add(1)
+		return nil
+	case QueueClose:
+		err := &CloseError{Code: StatusPolicyViolation, Reason: "send queue full"}
+		go ws.abort(err, StatusPolicyViolation)
+		return err
+	}
+
+	select {
+	case q.msgs <- m:
+		return nil
+	case <-ws.done:
+		return ErrClosed
+	}
+}
+
+// sendQueue returns the send queue, it is created by the first call.
+func (ws *Conn) sendQueue() *sendQueue {
+	if q := ws.queue.Load(); q != nil {
+		return q
+	}
+
+	ws.queueMu.Lock()
+	defer ws.queueMu.Unlock()
+
+	if q := ws.queue.Load(); q != nil {
+		return q
+	}
+
+	size := ws.queueSize
+	if size <= 0 {
+		size = SendQueueSize
+	}
+
+	q := &sendQueue{
+		msgs:   make(chan queuedMsg, size),
+		policy: ws.queuePolicy,
+	}
+	ws.queue.Store(q)
+	go ws.sendLoop(q)
+
+	return q
+}
+
+// sendLoop writes the queued messages until the connection is closed.
+func (ws *Conn) sendLoop(q *sendQueue) {
+	for {
+		var m queuedMsg
+		select {
+		case <-ws.done:
+			return
+		case m = <-q.msgs:
+		}
+
+		var err error
+		if m.pm != nil {
+			err = ws.WritePreparedMessage(m.pm)
+		} else {
+			err = ws.SendMsg(m.msgKind, m.data)
+		}
+
+		if err != nil {
+			q.err.Store(&err)
+
+			// drop the rest of the queue, and unblock waiting senders.
+			for {
+				select {
+				case <-q.msgs:
+					q.dropped.Add(1)
+				case <-ws.done:
+					return
+				}
+			}
+		}
+
+		q.sent.Add(1)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSetSendQueue(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	c := newConn(a, false, nil, nil)
+	defer c.Close()
+
+	if stats := c.QueueStats(); stats != (QueueStats{}) {
+		t.Fatalf("got %+v before the first message, want zero stats", stats)
+	}
+
+	if err := c.SetSendQueue(1, QueueDropNewest); err != nil {
+		t.Fatal(err)
+	}
+
+	// the peer does not read, so the first message blocks the queue.
+	for range 3 {
+		if err := c.Enqueue(TextMsg, "hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats := c.QueueStats(); stats.Cap != 1 || stats.Dropped == 0 {
+		t.Fatalf("got %+v, want a queue of 1 message which dropped messages", stats)
+	}
+
+	if err := c.SetSendQueue(8, QueueBlock); err != errQueueStarted {
+		t.Fatalf("SetSendQueue once the queue is in use: got %v, want %v", err, errQueueStarted)
+	}
+}
+
+func TestEnqueueAfterFailedWrite(t *testing.T) {
+	a, b := net.Pipe()
+	b.Close()
+
+	c := newConn(a, false, nil, nil)
+	defer c.Close()
+
+	if err := c.Enqueue(TextMsg, "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	// once the write failed, no message is accepted.
+	for {
+		err := c.Enqueue(TextMsg, "hello")
+		if err != nil {
+			break
+		}
+	}
+
+	if err := c.Enqueue(TextMsg, "hello"); err == nil {
+		t.Fatal("a message was accepted after the write failed")
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AcceptConn reads the opening handshake of a client from conn and upgrades
+// conn to a WebSocket Connection, without an http server. The request is
+// checked as by Accept and rejections are written to conn, which is closed
+// then. If the opening handshake is rejected, the error is a
+// [*HandshakeError].
+//
+// The handshake is bounded by HandShakeTimeout and MaxHeaderBytes as for a
+// connection accepted by Listener, the buffer which read the request is used
+// for reading the messages. A TLS connection is expected to be a [*tls.Conn]
+// whose handshake is performed first.
+func (wss *Server) AcceptConn(conn net.Conn) (*Conn, error) {
+	hc, ok := conn.(*handshakeConn)
+	if !ok {
+		hc = wss.limitHandshake(conn)
+	}
+
+	c, err := wss.acceptConn(hc)
+	if err != nil {
+		hc.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (wss *Server) acceptConn(hc *handshakeConn) (*Conn, error) {
+	var state *tls.ConnectionState
+	if tc, ok := hc.Conn.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			if hc.state.Load() == handshakeExpired {
+				return nil, &HandshakeError{Status: http.StatusRequestTimeout, Kind: HandshakeTimeout, Err: errHandshakeTimeout}
+			}
+
+			return nil, err
+		}
+
+		cs := tc.ConnectionState()
+		state = &cs
+	}
+
+	brw := bufio.NewReadWriter(bufio.NewReaderSize(hc, ReadBufSize), bufio.NewWriterSize(hc, WriteBufSize))
+
+	r, err := http.ReadRequest(brw.Reader)
+	if err != nil {
+		if hc.state.Load() == handshakeExpired {
+			return nil, &HandshakeError{Status: http.StatusRequestTimeout, Kind: HandshakeTimeout, Err: errHandshakeTimeout}
+		}
+
+		status := http.StatusBadRequest
+		if errors.Is(err, errHeaderTooLarge) {
+			status = http.StatusRequestHeaderFieldsTooLarge
+		}
+
+		hc.Write([]byte("HTTP/1.1 " + strconv.Itoa(status) + " " + http.StatusText(status) + "\r\nConnection: close\r\n\r\n"))
+		return nil, &HandshakeError{Status: status, Kind: HandshakeBadRequest, Err: err, msg: "bisoc: malformed request: " + err.Error()}
+	}
+
+	r.RemoteAddr = hc.RemoteAddr().String()
+	r.TLS = state
+
+	w := &connResponseWriter{conn: hc, brw: brw, header: make(http.Header)}
+	defer w.flush()
+
+	if r.ProtoMajor != 1 {
+		// the extended CONNECT requests of HTTP/2 are only accepted from an
+		// http server, see Accept.
+		return nil, wss.error(w, &HandshakeError{Status: http.StatusHTTPVersionNotSupported, Kind: HandshakeBadRequest, Request: r, msg: badHandShake + "unsupported protocol " + r.Proto})
+	}
+
+	return wss.upgrade(w, r, nil)
+}
+
+// Serve accepts connections from ln and calls handler with each connection
+// accepted by AcceptConn in a new goroutine, the connection is closed once
+// handler returns. The connections rejected by AcceptConn are logged to
+// ErrorLog. Temporary errors of ln, e.g. too many open files, are retried
+// with a backoff as by [http.Server.Serve], Serve returns any other error of
+// accepting from ln, e.g. once ln was closed.
+func (wss *Server) Serve(ln net.Listener, handler func(c *Conn)) error {
+	var tempDelay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(interface{ Temporary() bool }); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay = min(2*tempDelay, time.Second)
+				}
+
+				wss.logf("bisoc: accept error: %v; retrying in %v", err, tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+
+			return err
+		}
+		tempDelay = 0
+
+		go func() {
+			c, err := wss.AcceptConn(conn)
+			if err != nil {
+				wss.logf("bisoc: handshake error from %s: %v", conn.RemoteAddr(), err)
+				return
+			}
+			defer c.Close()
+
+			handler(c)
+		}()
+	}
+}
+
+func (wss *Server) logf(format string, args ...any) {
+	if wss.ErrorLog != nil {
+		wss.ErrorLog.Printf(format, args...)
+	}
+}
+
+// connResponseWriter is the [http.ResponseWriter] of a request read by
+// AcceptConn, responses are written to the connection with a
+// "Connection: close" header.
+type connResponseWriter struct {
+	conn        net.Conn
+	brw         *bufio.ReadWriter
+	header      http.Header
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (w *connResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *connResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader || w.hijacked {
+		return
+	}
+	w.wroteHeader = true
+
+	w.header.Del("Content-Length")
+	w.header.Del("Transfer-Encoding")
+	w.header.Set("Connection", "close")
+
+	w.brw.WriteString("HTTP/1.1 " + strconv.Itoa(status) + " " + http.StatusText(status) + "\r\n")
+	w.header.Write(w.brw)
+	w.brw.WriteString("\r\n")
+}
+
+func (w *connResponseWriter) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, http.ErrHijacked
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return w.brw.Write(p)
+}
+
+// flush writes the buffered response, unless the connection was hijacked.
+func (w *connResponseWriter) flush() {
+	if !w.hijacked {
+		w.brw.Flush()
+	}
+}
+
+func (w *connResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, http.ErrHijacked
+	}
+
+	if w.wroteHeader {
+		return nil, nil, errors.New("bisoc: response already written")
+	}
+	w.hijacked = true
+
+	return w.conn, w.brw, nil
+}
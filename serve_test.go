@@ -0,0 +1,95 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// scriptedListener returns the errors of errs from Accept and then the
+// connections of conns, Accept fails with net.ErrClosed once both are used.
+type scriptedListener struct {
+	net.Listener
+	errs  []error
+	conns []net.Conn
+}
+
+func (l *scriptedListener) Accept() (net.Conn, error) {
+	if len(l.errs) > 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		return nil, err
+	}
+
+	if len(l.conns) > 0 {
+		conn := l.conns[0]
+		l.conns = l.conns[1:]
+		return conn, nil
+	}
+
+	return nil, net.ErrClosed
+}
+
+// logLines sends every line logged to it on a channel.
+type logLines chan string
+
+func (l logLines) Write(p []byte) (int, error) {
+	l <- string(p)
+	return len(p), nil
+}
+
+func TestServe(t *testing.T) {
+	good, goodPeer := net.Pipe()
+	bad, badPeer := net.Pipe()
+	defer goodPeer.Close()
+
+	logs := make(logLines, 4)
+	wss := &Server{ErrorLog: log.New(logs, "", 0)}
+
+	// a temporary error of the listener does not end Serve.
+	ln := &scriptedListener{
+		errs:  []error{&net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}},
+		conns: []net.Conn{bad, good},
+	}
+
+	handled := make(chan struct{})
+	err := make(chan error, 1)
+	go func() {
+		err <- wss.Serve(ln, func(c *Conn) { close(handled) })
+	}()
+
+	go func() {
+		badPeer.Write([]byte("not a request\r\n\r\n"))
+		badPeer.Close()
+	}()
+
+	go func() {
+		goodPeer.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"))
+	}()
+
+	buf := make([]byte, 512)
+	n, _ := goodPeer.Read(buf)
+	if !strings.HasPrefix(string(buf[:n]), "HTTP/1.1 101 ") {
+		t.Fatalf("got response %q, want 101", buf[:n])
+	}
+	<-handled
+
+	if err := <-err; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Serve returned %v, want %v", err, net.ErrClosed)
+	}
+
+	var temporary, handshake bool
+	for !temporary || !handshake {
+		line := <-logs
+		temporary = temporary || strings.Contains(line, "accept error") && strings.Contains(line, "retrying in 5ms")
+		handshake = handshake || strings.Contains(line, "handshake error from")
+	}
+}
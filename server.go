@@ -6,32 +6,116 @@ package bisoc
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/base64"
 	"errors"
+	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Server struct {
-	// HandShakeTimeout is the duration for the handshake to complete.
+	// HandShakeTimeout is the duration for the handshake to complete, it
+	// bounds writing the response and, for a connection accepted by
+	// Listener or AcceptConn, the whole handshake from accepting the
+	// connection. Once it elapses, the handshake fails with a
+	// [*HandshakeError] of kind [HandshakeTimeout].
 	HandShakeTimeout time.Duration
 
+	// MaxHeaderBytes is the number of bytes a connection accepted by
+	// Listener or AcceptConn may send before its handshake completes. If
+	// zero, [MaxHeaderBytes] is used.
+	MaxHeaderBytes int
+
+	// FirstMessageTimeout is the duration for the first message of the
+	// client to arrive once its handshake completed, reading fails with a
+	// timeout afterwards. Setting a read deadline on the connection replaces
+	// it. If zero, there is no timeout.
+	FirstMessageTimeout time.Duration
+
 	// This function checks which origins are permitted by Server.
 	// If not provided, same origin policy will be applied.
 	CheckOrigin func(r *http.Request) bool
 
-	// Subprotocols specifies the server's supported protocols in order of
-	// preference.
+	// Subprotocols specifies the server's supported protocols, the first
+	// protocol offered by the client which is supported is selected.
 	Subprotocols []string
+
+	// PreferServerSubprotocols selects the first protocol of Subprotocols
+	// which is offered by the client instead, so that Subprotocols is in the
+	// server's order of preference.
+	PreferServerSubprotocols bool
+
+	// SelectSubprotocol selects the subprotocol from the protocols offered by
+	// the client in their order, an empty string selects no subprotocol. If
+	// it returns an error, the handshake is rejected with the status of a
+	// [*SubprotocolError] or with http.StatusBadRequest. If set, Subprotocols
+	// and PreferServerSubprotocols are not used.
+	SelectSubprotocol func(r *http.Request, offered []string) (string, error)
+
+	// Compression enables the permessage-deflate extension if the client
+	// offers it. If nil, compression is never negotiated.
+	Compression *PerMessageDeflate
+
+	// Extensions specifies the server's supported extensions in addition
+	// to Compression, they are negotiated in the order offered by the client.
+	Extensions []Extension
+
+	// PingInterval enables keepalive pings, the peer is pinged when nothing
+	// was received from it for PingInterval. If zero, no pings are sent.
+	// The pongs are only received while the connection is read, so a
+	// goroutine must keep reading from it, e.g. with NextReader, or the
+	// connection is closed once PongTimeout elapses.
+	PingInterval time.Duration
+
+	// PongTimeout is the duration to wait for the pong of a keepalive ping,
+	// the connection is closed with [StatusGoingAway] if it does not arrive
+	// in time. If zero, PingInterval is used.
+	PongTimeout time.Duration
+
+	// OnHandshakeError writes the response rejecting an opening handshake,
+	// e.g. with a JSON body. The Sec-WebSocket-Version header is already set
+	// if the version of the client is not supported. If nil, the response
+	// has the status of the error and its text as body.
+	OnHandshakeError func(w http.ResponseWriter, r *http.Request, err *HandshakeError)
+
+	// ShutdownCode is the status code sent to the peers by Shutdown, usually
+	// [StatusGoingAway] or [StatusServiceRestart]. If zero, StatusGoingAway
+	// is used.
+	ShutdownCode int
+
+	// ErrorLog logs the errors of Serve, i.e. the rejected handshakes and
+	// the temporary errors of the listener. If nil, errors are not logged.
+	ErrorLog *log.Logger
+
+	mu       sync.Mutex
+	conns    map[*Conn]struct{}
+	shutdown bool
 }
 
-// Accept accepts a connection and upgrades it to a WebSocket Connection.
-func (wss *Server) Accept(w http.ResponseWriter, r *http.Request) (*Conn, error) {
-	c, err := wss.upgrade(w, r)
+// Accept accepts a connection and upgrades it to a WebSocket Connection. If
+// the opening handshake is rejected, the error is a [*HandshakeError].
+//
+// The responseHeader is included in the response to the client's opening
+// handshake, e.g. to set cookies with the Set-Cookie header. It must not hold
+// the headers of the handshake itself (Upgrade, Connection and the
+// Sec-WebSocket-* headers) or the Content-Length and Transfer-Encoding
+// headers, the handshake is then rejected with an internal server error.
+//
+// Over HTTP/2 an extended CONNECT request is accepted as described in
+// RFC 8441, the connection is then carried by the request and response
+// streams, so the handler must not return before the connection is closed.
+// The http server of net/http only offers extended CONNECT to the clients if
+// the GODEBUG setting http2xconnect=1 is set.
+func (wss *Server) Accept(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	c, err := wss.upgrade(w, r, responseHeader)
 	if err != nil {
 		return nil, err
 	}
@@ -39,73 +123,178 @@ func (wss *Server) Accept(w http.ResponseWriter, r *http.Request) (*Conn, error)
 	return c, nil
 }
 
-// error generates http error response.
-func (wss *Server) error(w http.ResponseWriter, code int, reason string) error {
-	http.Error(w, http.StatusText(code), code)
-	return errors.New(reason)
+// Shutdown gracefully closes all connections accepted by the server. It
+// performs the closing handshake with every peer using ShutdownCode and waits
+// for the peers' close frames until ctx is done, then the remaining
+// connections are closed and the context's error is returned.
+//
+// If ShutdownCode is not a valid close code, an error is returned and no
+// connection is closed.
+//
+// Once Shutdown is called, Accept rejects new connections with
+// [ErrServerClosed]. Shutdown does not stop the http server itself, the
+// hijacked WebSocket connections are not tracked by [http.Server.Shutdown].
+func (wss *Server) Shutdown(ctx context.Context) error {
+	code := wss.ShutdownCode
+	if code == 0 {
+		code = StatusGoingAway
+	}
+
+	// an invalid code would fail every closing handshake before anything
+	// is closed.
+	if _, err := formatCloseMessage(code, ""); err != nil {
+		return err
+	}
+
+	wss.mu.Lock()
+	wss.shutdown = true
+	conns := make([]*Conn, 0, len(wss.conns))
+	for c := range wss.conns {
+		conns = append(conns, c)
+	}
+	wss.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Go(func() {
+			c.closeHandshake(ctx, code, "")
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	// unblock the close frames which are still being written.
+	for _, c := range conns {
+		c.Close()
+	}
+	<-done
+
+	return ctx.Err()
 }
 
-func (wss *Server) upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
-	if r.Method != http.MethodGet {
-		return nil, wss.error(w, http.StatusMethodNotAllowed, badHandShake+"request method is not GET")
+// track adds c to the connections of the server until it is closed, it
+// reports false if the server was shut down.
+func (wss *Server) track(c *Conn) bool {
+	wss.mu.Lock()
+	defer wss.mu.Unlock()
+
+	if wss.shutdown {
+		return false
 	}
 
-	if !headerContains(r.Header["Connection"], "upgrade") {
-		return nil, wss.error(w, http.StatusBadRequest, badHandShake+"'Connection' header of the request does not contains 'upgrade'")
+	if wss.conns == nil {
+		wss.conns = make(map[*Conn]struct{})
 	}
+	wss.conns[c] = struct{}{}
 
-	if r.Header.Get("Sec-Websocket-Version") != "13" {
-		return nil, wss.error(w, http.StatusBadRequest, "unsupported websocket version")
+	c.onClose = func() {
+		wss.mu.Lock()
+		delete(wss.conns, c)
+		wss.mu.Unlock()
+	}
+
+	return true
+}
+
+func (wss *Server) isShutdown() bool {
+	wss.mu.Lock()
+	defer wss.mu.Unlock()
+
+	return wss.shutdown
+}
+
+// error rejects the opening handshake with the response of e, see
+// OnHandshakeError.
+func (wss *Server) error(w http.ResponseWriter, e *HandshakeError) error {
+	if e.Kind == HandshakeBadVersion {
+		// RFC 6455 (Section 4.4)
+		//
+		// The server MUST respond with a |Sec-WebSocket-Version| header field
+		// indicating the version(s) the server is capable of understanding.
+		w.Header().Set("Sec-WebSocket-Version", "13")
 	}
 
-	if !headerContains(r.Header["Upgrade"], "websocket") {
-		return nil, wss.error(w, http.StatusUpgradeRequired, badHandShake+"'Upgrade' header of request does not contains 'websocket'")
+	if wss.OnHandshakeError != nil {
+		wss.OnHandshakeError(w, e.Request, e)
+	} else {
+		http.Error(w, http.StatusText(e.Status), e.Status)
 	}
 
-	cors := wss.CheckOrigin
-	if cors == nil {
-		// checks for the same origin.
-		cors = func(r *http.Request) bool {
-			origin := r.Header.Get("Origin")
-			if len(origin) == 0 {
-				return true
-			}
-			u, err := url.Parse(origin)
-			if err != nil {
-				return false
-			}
+	return e
+}
 
-			return u.Host == r.Host
-		}
+func (wss *Server) upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	if wss.isShutdown() {
+		return nil, wss.error(w, &HandshakeError{Status: http.StatusServiceUnavailable, Kind: HandshakeServerClosed, Request: r, Err: ErrServerClosed})
 	}
 
-	if !cors(r) {
-		return nil, wss.error(w, http.StatusForbidden, "request origin not allowed")
+	if err := checkResponseHeader(responseHeader); err != nil {
+		return nil, wss.error(w, &HandshakeError{Status: http.StatusInternalServerError, Kind: HandshakeBadResponseHeader, Request: r, msg: err.Error()})
 	}
 
-	challengeKey := r.Header.Get("Sec-Websocket-Key")
-	if !isChallengeKeyValid(challengeKey) {
-		return nil, wss.error(w, http.StatusBadRequest, "'Sec-WebSocket-Key' header must be a base64-encoded 16-byte string")
+	if e := wss.checkRequest(r); e != nil {
+		return nil, wss.error(w, e)
 	}
 
-	subprotocol := wss.selectSubProtocol(r)
+	subprotocol, err := wss.selectSubProtocol(r)
+	if err != nil {
+		status := http.StatusBadRequest
+		var se *SubprotocolError
+		if errors.As(err, &se) && se.Status != 0 {
+			status = se.Status
+		}
+
+		return nil, wss.error(w, &HandshakeError{Status: status, Kind: HandshakeBadSubprotocol, Request: r, Err: err})
+	}
+
+	extensions, transforms := acceptExtensions(supportedExtensions(wss.Compression, wss.Extensions), parseExtensions(r.Header))
+
+	if r.ProtoMajor == 2 {
+		return wss.acceptStream(w, r, responseHeader, subprotocol, extensions, transforms)
+	}
+
+	challengeKey := r.Header.Get("Sec-Websocket-Key")
 
 	rawConn, brw, err := http.NewResponseController(w).Hijack()
 	if err != nil {
-		return nil, wss.error(w, http.StatusInternalServerError, "hijack error: "+err.Error())
+		return nil, wss.error(w, &HandshakeError{Status: http.StatusInternalServerError, Kind: HandshakeHijackFailed, Request: r, Err: err, msg: "bisoc: hijack error: " + err.Error()})
 	}
 
 	// Cleanup! Close the network connection when returning an error.
+	var c *Conn
 	defer func() {
-		if rawConn != nil {
+		if rawConn == nil {
+			return
+		}
+
+		if c != nil {
+			// this also removes c from the tracked connections.
+			c.Close()
+		} else {
 			rawConn.Close()
 		}
 	}()
 
+	if !handshakeCompleted(rawConn) {
+		return nil, &HandshakeError{Status: http.StatusRequestTimeout, Kind: HandshakeTimeout, Request: r, Err: errHandshakeTimeout}
+	}
+
 	if brw.Reader.Buffered() > 0 {
 		// it is abnormal behaviour for client to send data before completion of the opening
-		// handshake, we must report this as an error to client.
-		return nil, wss.error(w, http.StatusBadRequest, "bisoc does not implement HTTP pipelining")
+		// handshake, we must report this as an error to client. The connection is already
+		// hijacked, so the response is written to it directly.
+		rawConn.Write([]byte("HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n"))
+		return nil, &HandshakeError{Status: http.StatusBadRequest, Kind: HandshakePipelined, Request: r, msg: "bisoc: HTTP pipelining is not implemented"}
 	}
 
 	// Setup Read and Write buffers for the server side connection.
@@ -122,8 +311,20 @@ func (wss *Server) upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error
 		writeBuf = buf
 	}
 
-	c := newConn(rawConn, false, br, writeBuf)
+	c = newConn(rawConn, false, br, writeBuf)
 	c.subprotocol = subprotocol
+	c.handshake = handshakeInfo(r, subprotocol, extensions)
+	c.setExtensions(transforms)
+
+	// Track the connection before the response is written, so that it is
+	// either rejected or closed by a concurrent Shutdown. The close frame of
+	// Shutdown is written after the response.
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if !wss.track(c) {
+		rawConn.Write([]byte("HTTP/1.1 503 Service Unavailable\r\nConnection: close\r\n\r\n"))
+		return nil, &HandshakeError{Status: http.StatusServiceUnavailable, Kind: HandshakeServerClosed, Request: r, Err: ErrServerClosed}
+	}
 
 	respBuf := buf
 	if len(c.writeBuf) > len(respBuf) {
@@ -142,6 +343,16 @@ func (wss *Server) upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error
 		respBuf = append(respBuf, c.subprotocol...)
 		respBuf = append(respBuf, "\r\n"...)
 	}
+	if extensions != "" {
+		respBuf = append(respBuf, "Sec-WebSocket-Extensions: "...)
+		respBuf = append(respBuf, extensions...)
+		respBuf = append(respBuf, "\r\n"...)
+	}
+	if len(responseHeader) > 0 {
+		hb := bytes.NewBuffer(respBuf)
+		responseHeader.Write(hb)
+		respBuf = hb.Bytes()
+	}
 	respBuf = append(respBuf, "\r\n"...)
 
 	// Set a HandShakeTimeout deadline or clear any deadline configured by the http server.
@@ -166,46 +377,284 @@ func (wss *Server) upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error
 		}
 	}
 
+	if err := c.setFirstMessageTimeout(wss.FirstMessageTimeout); err != nil {
+		return nil, err
+	}
+
+	c.startKeepalive(wss.PingInterval, wss.PongTimeout)
+
 	// Success! This stops the above deferred cleanup function from closing the connection.
 	rawConn = nil
 	return c, nil
 }
 
-func (wss *Server) selectSubProtocol(r *http.Request) string {
-	if wss.Subprotocols != nil {
-		clientProtocols := subProtocols(r)
-		for _, cp := range clientProtocols {
-			if slices.Contains(wss.Subprotocols, cp) {
-				return cp
+// CheckRequest checks the opening handshake r as Accept does, without
+// writing a response, e.g. before doing costly work for the connection. The
+// subprotocol is not checked. If r is rejected, the error is a
+// [*HandshakeError].
+func (wss *Server) CheckRequest(r *http.Request) error {
+	if e := wss.checkRequest(r); e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// checkRequest checks the method, the upgrade headers, the version, the
+// origin and the key of the opening handshake r.
+func (wss *Server) checkRequest(r *http.Request) *HandshakeError {
+	extendedConnect := r.ProtoMajor == 2
+	if extendedConnect {
+		// RFC 8441 (Section 4)
+		//
+		// The opening handshake over HTTP/2 is a CONNECT request with the
+		// :protocol pseudo-header field set to websocket.
+		if r.Method != http.MethodConnect {
+			return &HandshakeError{Status: http.StatusMethodNotAllowed, Kind: HandshakeMethodNotAllowed, Request: r, msg: badHandShake + "request method is not CONNECT"}
+		}
+
+		if r.Header.Get(":protocol") != "websocket" {
+			return &HandshakeError{Status: http.StatusBadRequest, Kind: HandshakeBadUpgrade, Request: r, msg: badHandShake + "':protocol' pseudo-header of the request is not 'websocket'"}
+		}
+	} else {
+		if r.Method != http.MethodGet {
+			return &HandshakeError{Status: http.StatusMethodNotAllowed, Kind: HandshakeMethodNotAllowed, Request: r, msg: badHandShake + "request method is not GET"}
+		}
+
+		if !headerContains(r.Header["Connection"], "upgrade") {
+			return &HandshakeError{Status: http.StatusBadRequest, Kind: HandshakeBadUpgrade, Request: r, msg: badHandShake + "'Connection' header of the request does not contains 'upgrade'"}
+		}
+	}
+
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		return &HandshakeError{Status: http.StatusBadRequest, Kind: HandshakeBadVersion, Request: r, msg: badHandShake + "unsupported websocket version"}
+	}
+
+	if !extendedConnect && !headerContains(r.Header["Upgrade"], "websocket") {
+		return &HandshakeError{Status: http.StatusUpgradeRequired, Kind: HandshakeBadUpgrade, Request: r, msg: badHandShake + "'Upgrade' header of request does not contains 'websocket'"}
+	}
+
+	cors := wss.CheckOrigin
+	if cors == nil {
+		// checks for the same origin.
+		cors = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if len(origin) == 0 {
+				return true
+			}
+			u, err := url.Parse(origin)
+			if err != nil {
+				return false
 			}
+
+			return u.Host == r.Host
 		}
 	}
 
-	return ""
+	if !cors(r) {
+		return &HandshakeError{Status: http.StatusForbidden, Kind: HandshakeOriginNotAllowed, Request: r, msg: badHandShake + "request origin not allowed"}
+	}
+
+	if !extendedConnect && !isChallengeKeyValid(r.Header.Get("Sec-Websocket-Key")) {
+		return &HandshakeError{Status: http.StatusBadRequest, Kind: HandshakeBadKey, Request: r, msg: badHandShake + "'Sec-WebSocket-Key' header must be a base64-encoded 16-byte string"}
+	}
+
+	return nil
 }
 
-func subProtocols(r *http.Request) []string {
-	header := strings.TrimSpace(r.Header.Get("Sec-Websocket-Protocol"))
-	if header == "" {
-		return nil
+// acceptStream accepts the extended CONNECT request r, the stream of the
+// request and response bodies carries the WebSocket connection as described
+// in RFC 8441 (Section 5).
+func (wss *Server) acceptStream(w http.ResponseWriter, r *http.Request, responseHeader http.Header, subprotocol, extensions string, transforms []ExtensionTransform) (*Conn, error) {
+	rc := http.NewResponseController(w)
+
+	// Set a HandShakeTimeout deadline or clear any deadline configured by the http server.
+	if wss.HandShakeTimeout > 0 {
+		if err := rc.SetWriteDeadline(time.Now().Add(wss.HandShakeTimeout)); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := rc.SetReadDeadline(time.Time{}); err != nil {
+			return nil, err
+		}
+
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			return nil, err
+		}
+	}
+
+	conn := newStreamConn(r.Body, &flushWriter{w: w, rc: rc}, func() {
+		r.Body.Close()
+	})
+	conn.remote = streamAddr(r.RemoteAddr)
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		conn.local = addr
+	}
+	conn.writeDeadline = rc.SetWriteDeadline
+
+	// The response must not be written once the handler returned.
+	conn.wait = true
+
+	c := newConn(conn, false, nil, nil)
+	c.subprotocol = subprotocol
+	c.handshake = handshakeInfo(r, subprotocol, extensions)
+	c.setExtensions(transforms)
+
+	// Track the connection before the response is written, see upgrade.
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if !wss.track(c) {
+		conn.Close()
+		return nil, wss.error(w, &HandshakeError{Status: http.StatusServiceUnavailable, Kind: HandshakeServerClosed, Request: r, Err: ErrServerClosed})
+	}
+
+	for k, vs := range responseHeader {
+		w.Header()[k] = vs
+	}
+	if subprotocol != "" {
+		w.Header().Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	if extensions != "" {
+		w.Header().Set("Sec-WebSocket-Extensions", extensions)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	// Remove the HandShakeTimeout deadline if applied.
+	if wss.HandShakeTimeout > 0 {
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if err := c.setFirstMessageTimeout(wss.FirstMessageTimeout); err != nil {
+		c.Close()
+		return nil, err
 	}
-	protocols := strings.Split(header, ",")
-	for i := range protocols {
-		protocols[i] = strings.TrimSpace(protocols[i])
+
+	c.startKeepalive(wss.PingInterval, wss.PongTimeout)
+	return c, nil
+}
+
+// checkResponseHeader checks the application specific headers of the
+// response to an opening handshake.
+func checkResponseHeader(h http.Header) error {
+	for k := range h {
+		if !isToken(k) {
+			return errors.New("bisoc: invalid response header name: " + k)
+		}
+
+		switch k := http.CanonicalHeaderKey(k); k {
+		case "Upgrade", "Connection", "Content-Length", "Transfer-Encoding":
+			return errors.New("bisoc: response header not allowed: " + k)
+		default:
+			if strings.HasPrefix(k, "Sec-Websocket-") {
+				return errors.New("bisoc: response header not allowed: " + k)
+			}
+		}
 	}
+
+	return nil
+}
+
+// supportedExtensions returns the extensions configured on a [Server] or
+// a [Dialer], compression is always the first one.
+func supportedExtensions(compression *PerMessageDeflate, exts []Extension) []Extension {
+	if compression == nil {
+		return exts
+	}
+
+	return append([]Extension{compression}, exts...)
+}
+
+// SubprotocolError is returned by [Server.SelectSubprotocol] to reject an
+// opening handshake with an HTTP status.
+type SubprotocolError struct {
+	Status int // status of the response, e.g. http.StatusBadRequest
+	Reason string
+}
+
+func (e *SubprotocolError) Error() string {
+	return "bisoc: subprotocol rejected: " + e.Reason
+}
+
+func (wss *Server) selectSubProtocol(r *http.Request) (string, error) {
+	clientProtocols := subProtocols(r.Header)
+
+	if wss.SelectSubprotocol != nil {
+		p, err := wss.SelectSubprotocol(r, clientProtocols)
+		if err != nil {
+			return "", err
+		}
+
+		// RFC 6455 (Section 4.2.2)
+		//
+		// The value chosen MUST be derived from the client's handshake.
+		if p != "" && !slices.Contains(clientProtocols, p) {
+			return "", &SubprotocolError{Status: http.StatusInternalServerError, Reason: "selected subprotocol " + p + " was not offered by the client"}
+		}
+
+		return p, nil
+	}
+
+	if wss.PreferServerSubprotocols {
+		for _, sp := range wss.Subprotocols {
+			if slices.Contains(clientProtocols, sp) {
+				return sp, nil
+			}
+		}
+
+		return "", nil
+	}
+
+	for _, cp := range clientProtocols {
+		if slices.Contains(wss.Subprotocols, cp) {
+			return cp, nil
+		}
+	}
+
+	return "", nil
+}
+
+// subProtocols returns the protocols of the Sec-WebSocket-Protocol headers,
+// which may be repeated.
+func subProtocols(h http.Header) []string {
+	var protocols []string
+	for _, v := range h.Values("Sec-Websocket-Protocol") {
+		for p := range strings.SplitSeq(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+
 	return protocols
 }
 
+// headerContains reports whether any of the comma separated tokens
+// in values is equal to target, ignoring case.
 func headerContains(values []string, target string) bool {
 	for i := range values {
-		if strings.EqualFold(values[i], target) {
-			return true
+		for token := range strings.SplitSeq(values[i], ",") {
+			if strings.EqualFold(strings.TrimSpace(token), target) {
+				return true
+			}
 		}
 	}
 
 	return false
 }
 
+// joinHeader joins values into a single comma separated header value.
+func joinHeader(values []string) string {
+	return strings.Join(values, ", ")
+}
+
 // Generate 'Sec-WebSocket-Accept' by concatenating the challengeKey with
 // [KEY_GUID] and return the base64-encoded form of the sha1 hash of this
 // concatenated string.
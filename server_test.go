@@ -0,0 +1,392 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	wss := &Server{ShutdownCode: StatusServiceRestart}
+	u := newTestServer(t, wss, echo)
+
+	conns := make([]*Conn, 2)
+	for i := range conns {
+		c, _, err := DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		conns[i] = c
+	}
+
+	closed := make(chan error, len(conns))
+	for _, c := range conns {
+		go func() {
+			_, _, err := c.RecvMsg()
+			closed <- err
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := wss.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for range conns {
+		var ce *CloseError
+		if err := <-closed; !errors.As(err, &ce) || ce.Code != StatusServiceRestart {
+			t.Fatalf("got %v, want a close error with status %d", err, StatusServiceRestart)
+		}
+	}
+
+	_, resp, err := DefaultDialer.Dial(u, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("dial after shutdown: got %v, want status %d", err, http.StatusServiceUnavailable)
+	}
+}
+
+func TestShutdownInvalidCode(t *testing.T) {
+	wss := &Server{ShutdownCode: StatusAbnormalClosure}
+	u := newTestServer(t, wss, echo)
+
+	c, _, err := DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := wss.Shutdown(context.Background()); err == nil {
+		t.Fatal("shutdown with an invalid close code succeeded")
+	}
+
+	// the connection is still open and tracked.
+	if err := c.SendMsg(TextMsg, "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, p, err := c.RecvMsg(); err != nil || string(p) != "hello" {
+		t.Fatalf("got %q, %v", p, err)
+	}
+
+	wss.mu.Lock()
+	n := len(wss.conns)
+	wss.mu.Unlock()
+
+	if n != 1 {
+		t.Fatalf("the server tracks %d connections, want 1", n)
+	}
+}
+
+func TestShutdownDuringHandshake(t *testing.T) {
+	// Shutdown is called after the server checked that it is not shut down,
+	// but before the connection is tracked.
+	wss := &Server{}
+	wss.CheckOrigin = func(*http.Request) bool {
+		wss.Shutdown(context.Background())
+		return true
+	}
+
+	rejected := make(chan error, 2)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := wss.Accept(w, r, nil)
+		if err == nil {
+			c.Close()
+		}
+		rejected <- err
+	}))
+	defer s.Close()
+
+	dialers := map[string]*Dialer{
+		"http/1.1": DefaultDialer,
+		"http/2":   {HTTP2Transport: &streamTransport{wss: wss, handler: echo}},
+	}
+
+	for name, d := range dialers {
+		t.Run(name, func(t *testing.T) {
+			wss.mu.Lock()
+			wss.shutdown = false
+			wss.mu.Unlock()
+
+			_, resp, err := d.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+			if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+				t.Fatalf("got %v, want status %d", err, http.StatusServiceUnavailable)
+			}
+
+			if name == "http/2" {
+				// the stream transport serves the request itself.
+				return
+			}
+
+			var he *HandshakeError
+			if err := <-rejected; !errors.As(err, &he) || he.Kind != HandshakeServerClosed || !errors.Is(err, ErrServerClosed) {
+				t.Fatalf("Accept returned %v, want a server closed handshake error", err)
+			}
+		})
+	}
+
+	wss.mu.Lock()
+	n := len(wss.conns)
+	wss.mu.Unlock()
+
+	if n != 0 {
+		t.Fatalf("the server tracks %d rejected connections", n)
+	}
+}
+
+func TestCheckResponseHeader(t *testing.T) {
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{"Set-Cookie", true},
+		{"X-Sec-Websocket-Key", true},
+		{"Upgrade", false},
+		{"connection", false},
+		{"Content-Length", false},
+		{"Transfer-Encoding", false},
+		{"Sec-WebSocket-Accept", false},
+		{"Sec-WebSocket-Protocol", false},
+		{"Sec-WebSocket-Extensions", false},
+		{"Sec-WebSocket-Version", false},
+		{"sec-websocket-key", false},
+		{"Sec-WebSocket-Foo", false},
+		{"Bad Name", false},
+	}
+
+	for _, tt := range tests {
+		err := checkResponseHeader(http.Header{tt.key: {"x"}})
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got %v, want ok %t", tt.key, err, tt.ok)
+		}
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	t.Run("AcceptConn", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer b.Close()
+
+		// the client connects and never sends its request.
+		_, err := (&Server{HandShakeTimeout: 50 * time.Millisecond}).AcceptConn(a)
+
+		var he *HandshakeError
+		if !errors.As(err, &he) || he.Kind != HandshakeTimeout || !errors.Is(err, errHandshakeTimeout) {
+			t.Fatalf("got %v, want a handshake error of kind %v", err, HandshakeTimeout)
+		}
+	})
+
+	t.Run("Accept", func(t *testing.T) {
+		errs := make(chan error, 1)
+		called := false
+		wss := &Server{
+			HandShakeTimeout: 50 * time.Millisecond,
+			OnHandshakeError: func(w http.ResponseWriter, r *http.Request, err *HandshakeError) { called = true },
+		}
+
+		s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the handler stalls past the timeout before accepting.
+			time.Sleep(200 * time.Millisecond)
+
+			_, err := wss.Accept(w, r, nil)
+			errs <- err
+		}))
+		s.Listener = wss.Listener(s.Listener)
+		s.Start()
+		defer s.Close()
+
+		DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+
+		err := <-errs
+		var he *HandshakeError
+		if !errors.As(err, &he) || he.Kind != HandshakeTimeout || he.Request == nil {
+			t.Fatalf("got %v, want a handshake error of kind %v", err, HandshakeTimeout)
+		}
+
+		if called {
+			t.Fatal("OnHandshakeError was called without a response to write")
+		}
+	})
+}
+
+func TestSelectSubprotocol(t *testing.T) {
+	offered := []string{"a", "b"}
+	tests := []struct {
+		name   string
+		wss    *Server
+		want   string // selected subprotocol
+		status int    // status of the rejection, 0 if accepted
+	}{
+		{"client order", &Server{Subprotocols: []string{"b", "a"}}, "a", 0},
+		{"server order", &Server{Subprotocols: []string{"b", "a"}, PreferServerSubprotocols: true}, "b", 0},
+		{"not supported", &Server{Subprotocols: []string{"c"}}, "", 0},
+		{"not supported in server order", &Server{Subprotocols: []string{"c"}, PreferServerSubprotocols: true}, "", 0},
+		{"selected", &Server{
+			Subprotocols: []string{"a"},
+			SelectSubprotocol: func(r *http.Request, offered []string) (string, error) {
+				return offered[len(offered)-1], nil
+			},
+		}, "b", 0},
+		{"none selected", &Server{
+			Subprotocols: []string{"a"},
+			SelectSubprotocol: func(r *http.Request, offered []string) (string, error) {
+				return "", nil
+			},
+		}, "", 0},
+		{"rejected with status", &Server{
+			SelectSubprotocol: func(r *http.Request, offered []string) (string, error) {
+				return "", &SubprotocolError{Status: http.StatusForbidden, Reason: "not allowed"}
+			},
+		}, "", http.StatusForbidden},
+		{"rejected", &Server{
+			SelectSubprotocol: func(r *http.Request, offered []string) (string, error) {
+				return "", errors.New("not allowed")
+			},
+		}, "", http.StatusBadRequest},
+		{"not offered", &Server{
+			SelectSubprotocol: func(r *http.Request, offered []string) (string, error) {
+				return "c", nil
+			},
+		}, "", http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejected := make(chan error, 1)
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c, err := tt.wss.Accept(w, r, nil)
+				if err != nil {
+					rejected <- err
+					return
+				}
+				c.Close()
+			}))
+			defer s.Close()
+
+			d := &Dialer{Subprotocols: offered}
+			c, resp, err := d.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+			if tt.status != 0 {
+				if err == nil || resp == nil || resp.StatusCode != tt.status {
+					t.Fatalf("got %v, want status %d", err, tt.status)
+				}
+
+				var he *HandshakeError
+				if err := <-rejected; !errors.As(err, &he) || he.Kind != HandshakeBadSubprotocol || he.Status != tt.status {
+					t.Fatalf("Accept returned %v, want a subprotocol error with status %d", err, tt.status)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			if p := c.Subprotocol(); p != tt.want {
+				t.Fatalf("got subprotocol %q, want %q", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnHandshakeError(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(r *http.Request)
+		status int
+		kind   HandshakeErrorKind
+	}{
+		{"method", func(r *http.Request) { r.Method = http.MethodPost }, http.StatusMethodNotAllowed, HandshakeMethodNotAllowed},
+		{"version", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Version", "8") }, http.StatusBadRequest, HandshakeBadVersion},
+		{"origin", func(r *http.Request) { r.Header.Set("Origin", "http://example.com") }, http.StatusForbidden, HandshakeOriginNotAllowed},
+		{"key", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "short") }, http.StatusBadRequest, HandshakeBadKey},
+	}
+
+	for _, hook := range []bool{true, false} {
+		rejected := make(chan *HandshakeError, 1)
+		wss := &Server{}
+		if hook {
+			wss.OnHandshakeError = func(w http.ResponseWriter, r *http.Request, err *HandshakeError) {
+				if r != err.Request {
+					t.Error("the request of the hook is not the request of the error")
+				}
+				rejected <- err
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(err.Status)
+				fmt.Fprintf(w, `{"error":%q}`, err.Kind)
+			}
+		}
+
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c, err := wss.Accept(w, r, nil); err == nil {
+				c.Close()
+			}
+		}))
+		defer s.Close()
+
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/hook=%t", tt.name, hook), func(t *testing.T) {
+				r, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+				r.Header.Set("Connection", "Upgrade")
+				r.Header.Set("Upgrade", "websocket")
+				r.Header.Set("Sec-WebSocket-Version", "13")
+				r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+				tt.modify(r)
+
+				resp, err := http.DefaultClient.Do(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				var got *HandshakeError
+				if hook {
+					got = <-rejected
+				}
+
+				if resp.StatusCode != tt.status {
+					t.Fatalf("got status %d, want %d", resp.StatusCode, tt.status)
+				}
+
+				// RFC 6455 (Section 4.4)
+				version := resp.Header.Get("Sec-WebSocket-Version")
+				if tt.kind == HandshakeBadVersion && version != "13" {
+					t.Fatalf("got Sec-WebSocket-Version %q, want 13", version)
+				} else if tt.kind != HandshakeBadVersion && version != "" {
+					t.Fatalf("got Sec-WebSocket-Version %q in a %s rejection", version, tt.kind)
+				}
+
+				if !hook {
+					if want := http.StatusText(tt.status) + "\n"; string(body) != want {
+						t.Fatalf("got body %q, want %q", body, want)
+					}
+					return
+				}
+
+				if got.Kind != tt.kind || got.Status != tt.status {
+					t.Fatalf("the hook got %v of kind %s, want kind %s", got, got.Kind, tt.kind)
+				}
+
+				if want := `{"error":"` + tt.kind.String() + `"}`; string(body) != want || resp.Header.Get("Content-Type") != "application/json" {
+					t.Fatalf("got body %q, want the response of the hook %q", body, want)
+				}
+			})
+		}
+	}
+}
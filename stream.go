@@ -0,0 +1,116 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// streamConn is the connection of a WebSocket over an HTTP/2 stream, as
+// described in RFC 8441. The stream is bridged to one end of a [net.Pipe],
+// so that reads and writes can be interrupted by deadlines as on a network
+// connection.
+type streamConn struct {
+	net.Conn // end of the pipe used by the Conn
+
+	local, remote net.Addr
+
+	// writeDeadline sets the deadline of the stream writes, if supported.
+	writeDeadline func(t time.Time) error
+
+	// done is closed once the stream is no longer written.
+	done chan struct{}
+	wait bool // Close waits for done
+}
+
+// newStreamConn bridges the stream read from r and written to w, closeStream
+// is called once the connection is closed or the stream can not be written.
+func newStreamConn(r io.Reader, w io.Writer, closeStream func()) *streamConn {
+	a, b := net.Pipe()
+	c := &streamConn{
+		Conn: a,
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		io.Copy(b, r)
+		b.Close()
+	}()
+
+	go func() {
+		defer close(c.done)
+
+		io.Copy(w, b)
+		b.Close()
+		closeStream()
+	}()
+
+	return c
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	if err := c.SetWriteDeadline(t); err != nil {
+		return err
+	}
+
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	if c.writeDeadline != nil {
+		if err := c.writeDeadline(t); err != nil {
+			return err
+		}
+	}
+
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *streamConn) Close() error {
+	err := c.Conn.Close()
+	if c.wait {
+		<-c.done
+	}
+
+	return err
+}
+
+// flushWriter flushes every write to the response stream.
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	return n, fw.rc.Flush()
+}
+
+// streamAddr is the address of a peer which is only known by its string
+// form, e.g. the remote address of an [http.Request].
+type streamAddr string
+
+func (a streamAddr) Network() string {
+	return "tcp"
+}
+
+func (a streamAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,234 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamTransport is an HTTP/2 transport stand-in which serves the extended
+// CONNECT requests of a Dialer with wss in process, the request and response
+// bodies are the streams of the connection.
+type streamTransport struct {
+	wss      *Server
+	handler  func(c *Conn)
+	requests chan *http.Request // requests sent by the dialer
+}
+
+func (st *streamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if st.requests != nil {
+		st.requests <- req
+	}
+
+	r := req.Clone(req.Context())
+	r.Body = req.Body
+	r.URL = &url.URL{Path: req.URL.Path, RawQuery: req.URL.RawQuery}
+	r.RequestURI = req.URL.RequestURI()
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	body, pw := io.Pipe()
+	w := &streamResponseWriter{header: make(http.Header), body: pw, wrote: make(chan struct{})}
+
+	go func() {
+		defer pw.Close()
+		defer w.WriteHeader(http.StatusOK)
+
+		c, err := st.wss.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		st.handler(c)
+	}()
+
+	select {
+	case <-w.wrote:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	return &http.Response{
+		Status:     strconv.Itoa(w.status) + " " + http.StatusText(w.status),
+		StatusCode: w.status,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     w.sent,
+		Body:       body,
+		Request:    req,
+	}, nil
+}
+
+// streamResponseWriter is the response stream of a streamTransport.
+type streamResponseWriter struct {
+	header http.Header
+	body   io.Writer
+
+	once   sync.Once
+	wrote  chan struct{} // closed once the response header was written
+	status int
+	sent   http.Header
+}
+
+func (w *streamResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *streamResponseWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		w.status = status
+		w.sent = w.header.Clone()
+		close(w.wrote)
+	})
+}
+
+func (w *streamResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+func (w *streamResponseWriter) Flush() {}
+
+func (w *streamResponseWriter) SetReadDeadline(time.Time) error { return nil }
+
+func (w *streamResponseWriter) SetWriteDeadline(time.Time) error { return nil }
+
+func TestDialStream(t *testing.T) {
+	accepted := make(chan HandshakeInfo, 1)
+	closed := make(chan error, 1)
+	st := &streamTransport{
+		wss: &Server{Subprotocols: []string{"chat"}, Compression: &PerMessageDeflate{}},
+		handler: func(c *Conn) {
+			accepted <- c.Handshake()
+			echo(c)
+
+			_, _, err := c.RecvMsg()
+			closed <- err
+		},
+		requests: make(chan *http.Request, 1),
+	}
+
+	d := &Dialer{HTTP2Transport: st, Subprotocols: []string{"chat"}, Compression: &PerMessageDeflate{}}
+	c, resp, err := d.Dial("ws://example.com/chat?room=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// RFC 8441 (Section 4)
+	req := <-st.requests
+	if req.Method != http.MethodConnect || req.ProtoMajor != 2 || req.Header.Get(":protocol") != "websocket" {
+		t.Fatalf("got %s %s with :protocol %q, want an extended CONNECT request", req.Method, req.Proto, req.Header.Get(":protocol"))
+	}
+
+	if req.URL.Scheme != "http" || req.URL.RequestURI() != "/chat?room=1" {
+		t.Fatalf("got request url %s, want the http url of the dialed url", req.URL)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+
+	if p := c.Subprotocol(); p != "chat" {
+		t.Fatalf("got subprotocol %q, want chat", p)
+	}
+
+	server := <-accepted
+	for _, hi := range []HandshakeInfo{server, c.Handshake()} {
+		if _, ok := hi.Header[":protocol"]; ok {
+			t.Fatal("the :protocol pseudo-header is exposed as a header")
+		}
+
+		if hi.Extensions == "" {
+			t.Fatal("compression was not negotiated")
+		}
+	}
+
+	if server.URL.Path != "/chat" || server.Host != "example.com" {
+		t.Fatalf("the server accepted %s of host %s, want /chat of example.com", server.URL, server.Host)
+	}
+
+	for _, m := range []string{"hello", string(make([]byte, 64<<10))} {
+		if err := c.SendMsg(BinMsg, m); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, p, err := c.RecvMsg(); err != nil || string(p) != m {
+			t.Fatalf("got %d bytes, %v, want the echo of %d bytes", len(p), err, len(m))
+		}
+	}
+
+	if err := c.CloseWithCode(4000, "done"); err != nil {
+		t.Fatal(err)
+	}
+
+	var ce *CloseError
+	if err := <-closed; !errors.As(err, &ce) || ce.Code != 4000 {
+		t.Fatalf("the server got %v, want the close frame 4000", err)
+	}
+}
+
+func TestDialStreamRejected(t *testing.T) {
+	st := &streamTransport{
+		wss:     &Server{CheckOrigin: func(*http.Request) bool { return false }},
+		handler: echo,
+	}
+
+	_, resp, err := (&Dialer{HTTP2Transport: st}).Dial("wss://example.com/", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("got %v, want the 403 response of the server", err)
+	}
+}
+
+func TestCheckRequestExtendedConnect(t *testing.T) {
+	request := func(method, protocol string) *http.Request {
+		r := &http.Request{
+			Method:     method,
+			Proto:      "HTTP/2.0",
+			ProtoMajor: 2,
+			Host:       "example.com",
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{"Sec-Websocket-Version": {"13"}},
+		}
+		if protocol != "" {
+			r.Header.Set(":protocol", protocol)
+		}
+
+		return r
+	}
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		kind HandshakeErrorKind
+	}{
+		// no Sec-WebSocket-Key is sent over HTTP/2, RFC 8441 (Section 5).
+		{"valid", request(http.MethodConnect, "websocket"), 0},
+		{"GET", request(http.MethodGet, "websocket"), HandshakeMethodNotAllowed},
+		{"no protocol", request(http.MethodConnect, ""), HandshakeBadUpgrade},
+		{"other protocol", request(http.MethodConnect, "chat"), HandshakeBadUpgrade},
+	}
+
+	for _, tt := range tests {
+		err := (&Server{}).checkRequest(tt.r)
+		if tt.kind == 0 {
+			if err != nil {
+				t.Errorf("%s: got %v, want no error", tt.name, err)
+			}
+			continue
+		}
+
+		if err == nil || err.Kind != tt.kind {
+			t.Errorf("%s: got %v, want a handshake error of kind %v", tt.name, err, tt.kind)
+		}
+	}
+}
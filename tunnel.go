@@ -0,0 +1,296 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// proxyURL returns the url of the proxy for the opening handshake req, or nil
+// if the server is dialed directly.
+func (d *Dialer) proxyURL(req *http.Request) (*url.URL, error) {
+	if d.Proxy == nil {
+		return nil, nil
+	}
+
+	// the Proxy functions of net/http only know the http and https schemes.
+	u := *req.URL
+	u.Scheme = "http"
+	if req.URL.Scheme == "wss" {
+		u.Scheme = "https"
+	}
+
+	r := req.WithContext(req.Context())
+	r.URL = &u
+	return d.Proxy(r)
+}
+
+// proxyAddr returns the host and port of the proxy, using the default port
+// of the proxy scheme if the url does not contain one.
+func proxyAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// tunnel asks the proxy connected with conn to open a tunnel to addr.
+func (d *Dialer) tunnel(ctx context.Context, conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	switch proxy.Scheme {
+	case "http":
+		return conn, httpConnect(conn, proxy, addr)
+	case "https":
+		cfg := d.TLSClientConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		} else {
+			cfg = cfg.Clone()
+		}
+		cfg.ServerName = proxy.Hostname()
+		cfg.NextProtos = []string{"http/1.1"}
+
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return conn, tlsHandshakeErr(ctx, cfg.ServerName, err)
+		}
+
+		return tlsConn, httpConnect(tlsConn, proxy, addr)
+	case "socks5", "socks5h":
+		return conn, socks5Connect(ctx, conn, proxy, addr)
+	default:
+		return conn, errors.New("bisoc: unsupported proxy scheme: " + proxy.Scheme)
+	}
+}
+
+// httpConnect opens a tunnel to addr with the CONNECT method, as described in
+// RFC 9110 (Section 9.3.6). The credentials of the proxy url are sent with the
+// basic authentication scheme.
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) error {
+	req := &http.Request{
+		Method:     http.MethodConnect,
+		URL:        &url.URL{Opaque: addr},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       addr,
+	}
+
+	if user := proxy.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	// The server does not send anything before the opening handshake, so
+	// nothing past the response is buffered.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("bisoc: proxy refused the tunnel: " + resp.Status)
+	}
+
+	return nil
+}
+
+// SOCKS5 constants, as described in RFC 1928 and RFC 1929.
+const (
+	socks5Version      = 0x05
+	socks5NoAuth       = 0x00
+	socks5PasswordAuth = 0x02
+	socks5NoAcceptable = 0xFF
+	socks5CmdConnect   = 0x01
+	socks5IPv4         = 0x01
+	socks5Domain       = 0x03
+	socks5IPv6         = 0x04
+	socks5PasswordVer  = 0x01
+	socks5Succeeded    = 0x00
+	maxSocks5FieldLen  = 255
+)
+
+var socks5Errors = []string{
+	"",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// socks5Connect opens a tunnel to addr through a SOCKS5 proxy, as described
+// in RFC 1928. The credentials of the proxy url are sent with the
+// username/password authentication of RFC 1929. Host names are resolved
+// locally for the socks5 scheme and by the proxy for the socks5h scheme.
+func socks5Connect(ctx context.Context, conn net.Conn, proxy *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if proxy.Scheme == "socks5" && net.ParseIP(host) == nil {
+		ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return err
+		}
+
+		host = ips[0].Unmap().String()
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return errors.New("bisoc: invalid port: " + portStr)
+	}
+
+	// method selection
+	methods := []byte{socks5NoAuth}
+	if proxy.User != nil {
+		methods = append(methods, socks5PasswordAuth)
+	}
+
+	b := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+
+	reply, err := readBytes(conn, 2)
+	if err != nil {
+		return err
+	}
+
+	if reply[0] != socks5Version {
+		return errors.New("bisoc: unexpected SOCKS version " + strconv.Itoa(int(reply[0])))
+	}
+
+	switch reply[1] {
+	case socks5NoAuth:
+	case socks5PasswordAuth:
+		if proxy.User == nil {
+			return errors.New("bisoc: SOCKS proxy requires authentication")
+		}
+
+		if err := socks5Authenticate(conn, proxy.User); err != nil {
+			return err
+		}
+	case socks5NoAcceptable:
+		return errors.New("bisoc: no acceptable SOCKS authentication method")
+	default:
+		return errors.New("bisoc: unsupported SOCKS authentication method " + strconv.Itoa(int(reply[1])))
+	}
+
+	// connect request
+	b = []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, socks5IPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, socks5IPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > maxSocks5FieldLen {
+			return errors.New("bisoc: host name too long: " + host)
+		}
+
+		b = append(b, socks5Domain, byte(len(host)))
+		b = append(b, host...)
+	}
+	b = append(b, byte(port>>8), byte(port))
+
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+
+	reply, err = readBytes(conn, 4)
+	if err != nil {
+		return err
+	}
+
+	if reply[1] != socks5Succeeded {
+		msg := "unknown error " + strconv.Itoa(int(reply[1]))
+		if int(reply[1]) < len(socks5Errors) {
+			msg = socks5Errors[reply[1]]
+		}
+
+		return errors.New("bisoc: SOCKS proxy refused the tunnel: " + msg)
+	}
+
+	// skip the bound address and port
+	var n int
+	switch reply[3] {
+	case socks5IPv4:
+		n = net.IPv4len
+	case socks5IPv6:
+		n = net.IPv6len
+	case socks5Domain:
+		l, err := readBytes(conn, 1)
+		if err != nil {
+			return err
+		}
+		n = int(l[0])
+	default:
+		return errors.New("bisoc: unexpected SOCKS address type " + strconv.Itoa(int(reply[3])))
+	}
+
+	_, err = io.CopyN(io.Discard, conn, int64(n+2))
+	return err
+}
+
+// socks5Authenticate performs the username/password authentication of
+// RFC 1929.
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > maxSocks5FieldLen || len(password) > maxSocks5FieldLen {
+		return errors.New("bisoc: SOCKS username or password too long")
+	}
+
+	b := []byte{socks5PasswordVer, byte(len(username))}
+	b = append(b, username...)
+	b = append(b, byte(len(password)))
+	b = append(b, password...)
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+
+	reply, err := readBytes(conn, 2)
+	if err != nil {
+		return err
+	}
+
+	if reply[1] != socks5Succeeded {
+		return errors.New("bisoc: SOCKS authentication failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,336 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// relay copies between a and b until both directions are done.
+func relay(a, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+
+	io.Copy(b, a)
+	b.Close()
+}
+
+// newConnectProxy starts a stand-in http proxy opening tunnels with the
+// CONNECT method, it requires the credentials of user if it is not nil.
+func newConnectProxy(t *testing.T, user *url.Userinfo, tunnels *atomic.Int32) *url.URL {
+	t.Helper()
+
+	s := httptest.NewServer(connectHandler(user, tunnels))
+	t.Cleanup(s.Close)
+
+	u, _ := url.Parse(s.URL)
+	return u
+}
+
+// connectHandler is the handler of a stand-in proxy, see newConnectProxy.
+func connectHandler(user *url.Userinfo, tunnels *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if user != nil {
+			username, password, ok := (&http.Request{Header: http.Header{"Authorization": r.Header["Proxy-Authorization"]}}).BasicAuth()
+			want, _ := user.Password()
+			if !ok || username != user.Username() || password != want {
+				w.Header().Set("Proxy-Authenticate", "Basic")
+				http.Error(w, http.StatusText(http.StatusProxyAuthRequired), http.StatusProxyAuthRequired)
+				return
+			}
+		}
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+
+		tunnels.Add(1)
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		relay(conn, upstream)
+	})
+}
+
+// newSOCKS5Proxy starts a stand-in SOCKS5 proxy which connects every tunnel
+// to target, the requested hosts are sent to hosts. It requires the
+// credentials of user if it is not nil.
+func newSOCKS5Proxy(t *testing.T, user *url.Userinfo, target string, hosts chan<- string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveSOCKS5(conn, user, target, hosts)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func serveSOCKS5(conn net.Conn, user *url.Userinfo, target string, hosts chan<- string) {
+	defer conn.Close()
+
+	// method selection
+	b, err := readBytes(conn, 2)
+	if err != nil {
+		return
+	}
+
+	methods, err := readBytes(conn, int(b[1]))
+	if err != nil {
+		return
+	}
+
+	method := byte(socks5NoAuth)
+	if user != nil {
+		method = socks5PasswordAuth
+	}
+
+	if !strings.ContainsRune(string(methods), rune(method)) {
+		conn.Write([]byte{socks5Version, socks5NoAcceptable})
+		return
+	}
+	conn.Write([]byte{socks5Version, method})
+
+	if user != nil {
+		b, err := readBytes(conn, 2)
+		if err != nil {
+			return
+		}
+
+		username, err := readBytes(conn, int(b[1]))
+		if err != nil {
+			return
+		}
+
+		l, err := readBytes(conn, 1)
+		if err != nil {
+			return
+		}
+
+		password, err := readBytes(conn, int(l[0]))
+		if err != nil {
+			return
+		}
+
+		if want, _ := user.Password(); string(username) != user.Username() || string(password) != want {
+			conn.Write([]byte{socks5PasswordVer, 1})
+			return
+		}
+		conn.Write([]byte{socks5PasswordVer, socks5Succeeded})
+	}
+
+	// connect request
+	req, err := readBytes(conn, 4)
+	if err != nil {
+		return
+	}
+
+	var host string
+	switch req[3] {
+	case socks5IPv4, socks5IPv6:
+		n := net.IPv4len
+		if req[3] == socks5IPv6 {
+			n = net.IPv6len
+		}
+
+		ip, err := readBytes(conn, n)
+		if err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case socks5Domain:
+		l, err := readBytes(conn, 1)
+		if err != nil {
+			return
+		}
+
+		name, err := readBytes(conn, int(l[0]))
+		if err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+
+	port, err := readBytes(conn, 2)
+	if err != nil {
+		return
+	}
+	hosts <- net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{socks5Version, 5, 0, socks5IPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+
+	conn.Write([]byte{socks5Version, socks5Succeeded, 0, socks5IPv4, 127, 0, 0, 1, 0, 0})
+	relay(conn, upstream)
+}
+
+// roundTrip dials u with d and checks that a message is echoed.
+func roundTrip(t *testing.T, d *Dialer, u string) {
+	t.Helper()
+
+	c, _, err := d.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.SendMsg(TextMsg, "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, p, err := c.RecvMsg(); err != nil || string(p) != "hello" {
+		t.Fatalf("got %q, %v", p, err)
+	}
+}
+
+func TestDialHTTPProxy(t *testing.T) {
+	u := newTestServer(t, &Server{}, echo)
+
+	t.Run("no auth", func(t *testing.T) {
+		var tunnels atomic.Int32
+		proxy := newConnectProxy(t, nil, &tunnels)
+
+		roundTrip(t, &Dialer{Proxy: http.ProxyURL(proxy)}, u)
+		if tunnels.Load() != 1 {
+			t.Fatal("the connection was not tunneled through the proxy")
+		}
+	})
+
+	t.Run("basic auth", func(t *testing.T) {
+		var tunnels atomic.Int32
+		user := url.UserPassword("user", "secret")
+		proxy := newConnectProxy(t, user, &tunnels)
+		proxy.User = user
+
+		roundTrip(t, &Dialer{Proxy: http.ProxyURL(proxy)}, u)
+		if tunnels.Load() != 1 {
+			t.Fatal("the connection was not tunneled through the proxy")
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		var tunnels atomic.Int32
+		proxy := newConnectProxy(t, url.UserPassword("user", "secret"), &tunnels)
+		proxy.User = url.UserPassword("user", "wrong")
+
+		_, _, err := (&Dialer{Proxy: http.ProxyURL(proxy)}).Dial(u, nil)
+		if err == nil || !strings.Contains(err.Error(), "407") {
+			t.Fatalf("got %v, want the 407 response of the proxy", err)
+		}
+	})
+}
+
+func TestDialSOCKS5Proxy(t *testing.T) {
+	u := newTestServer(t, &Server{}, echo)
+	target := strings.TrimPrefix(u, "ws://")
+	_, port, _ := net.SplitHostPort(target)
+
+	tests := []struct {
+		name   string
+		scheme string
+		user   *url.Userinfo
+		host   string // host of the dialed url
+		want   string // host requested from the proxy, empty for a loopback address
+	}{
+		{"no auth", "socks5", nil, "127.0.0.1", "127.0.0.1"},
+		{"password", "socks5", url.UserPassword("user", "secret"), "127.0.0.1", "127.0.0.1"},
+		{"local resolution", "socks5", nil, "localhost", ""},
+		{"remote resolution", "socks5h", nil, "localhost", "localhost"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts := make(chan string, 1)
+			proxy := &url.URL{Scheme: tt.scheme, Host: newSOCKS5Proxy(t, tt.user, target, hosts), User: tt.user}
+
+			roundTrip(t, &Dialer{Proxy: http.ProxyURL(proxy)}, "ws://"+net.JoinHostPort(tt.host, port))
+
+			host, _, _ := net.SplitHostPort(<-hosts)
+			if tt.want == "" {
+				if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+					t.Fatalf("the proxy was asked for %s, want a loopback address", host)
+				}
+			} else if host != tt.want {
+				t.Fatalf("the proxy was asked for %s, want %s", host, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialProxyBypass(t *testing.T) {
+	u := newTestServer(t, &Server{}, echo)
+	target, _ := url.Parse(u)
+
+	var tunnels atomic.Int32
+	proxy := newConnectProxy(t, nil, &tunnels)
+
+	// a NO_PROXY style list, the hosts listed are dialed directly.
+	noProxy := []string{target.Hostname()}
+	d := &Dialer{
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			if r.URL.Scheme != "http" {
+				t.Errorf("Proxy was called with scheme %q, want http", r.URL.Scheme)
+			}
+
+			for _, host := range noProxy {
+				if r.URL.Hostname() == host {
+					return nil, nil
+				}
+			}
+
+			return proxy, nil
+		},
+	}
+
+	roundTrip(t, d, u)
+	if tunnels.Load() != 0 {
+		t.Fatal("a host listed in NO_PROXY was tunneled through the proxy")
+	}
+
+	noProxy = nil
+	roundTrip(t, d, u)
+	if tunnels.Load() != 1 {
+		t.Fatal("the connection was not tunneled through the proxy")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// utf8Validator validates UTF-8 encoded text incrementally, a code point
+// may be split across calls to validate.
+type utf8Validator struct {
+	need   int  // continuation bytes still expected
+	lo, hi byte // valid range of the next continuation byte
+}
+
+// validate reports whether p is a valid continuation of the text so far. It
+// fails as soon as a byte can not be part of valid UTF-8, even if the code
+// point is not complete yet.
+func (v *utf8Validator) validate(p []byte) bool {
+	// finish the code point split by the previous call.
+	for v.need > 0 && len(p) > 0 {
+		if !v.next(p[0]) {
+			return false
+		}
+		p = p[1:]
+	}
+
+	// validate all complete code points at once, only an incomplete code
+	// point at the end is validated byte by byte.
+	cut := len(p)
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+
+	if !utf8.Valid(p[:cut]) {
+		return false
+	}
+
+	for _, b := range p[cut:] {
+		if !v.next(b) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// next validates a single byte, the valid ranges are taken from the
+// UTF-8 syntax described in RFC 3629 (Section 4).
+func (v *utf8Validator) next(b byte) bool {
+	if v.need > 0 {
+		if b < v.lo || b > v.hi {
+			return false
+		}
+
+		v.need--
+		v.lo, v.hi = 0x80, 0xBF
+		return true
+	}
+
+	switch {
+	case b < utf8.RuneSelf:
+	case b >= 0xC2 && b <= 0xDF:
+		v.need, v.lo, v.hi = 1, 0x80, 0xBF
+	case b == 0xE0:
+		v.need, v.lo, v.hi = 2, 0xA0, 0xBF
+	case b >= 0xE1 && b <= 0xEC, b == 0xEE, b == 0xEF:
+		v.need, v.lo, v.hi = 2, 0x80, 0xBF
+	case b == 0xED:
+		v.need, v.lo, v.hi = 2, 0x80, 0x9F
+	case b == 0xF0:
+		v.need, v.lo, v.hi = 3, 0x90, 0xBF
+	case b >= 0xF1 && b <= 0xF3:
+		v.need, v.lo, v.hi = 3, 0x80, 0xBF
+	case b == 0xF4:
+		v.need, v.lo, v.hi = 3, 0x80, 0x8F
+	default:
+		return false
+	}
+
+	return true
+}
+
+// complete reports whether the text does not end in the middle of a code point.
+func (v *utf8Validator) complete() bool {
+	return v.need == 0
+}
+
+// utf8Reader validates a text message while it is being read.
+//
+// RFC 6455 (Section 8.1)
+//
+// When an endpoint is to interpret a byte stream as UTF-8 but finds
+// that the byte stream is not, in fact, a valid UTF-8 stream, that
+// endpoint must fail the connection.
+type utf8Reader struct {
+	r io.Reader
+	v utf8Validator
+}
+
+func (ur *utf8Reader) Read(p []byte) (int, error) {
+	n, err := ur.r.Read(p)
+	if !ur.v.validate(p[:n]) || (err == io.EOF && !ur.v.complete()) {
+		return 0, &CloseError{Code: StatusInvalidFramePayloadData, Reason: "invalid utf8 encoded text"}
+	}
+
+	return n, err
+}
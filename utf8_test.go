@@ -0,0 +1,141 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestUTF8Validator(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunks   []string
+		valid    int  // number of chunks accepted by validate
+		complete bool // result of complete once all valid chunks were validated
+	}{
+		{"ascii", []string{"hello"}, 1, true},
+		{"multi-byte", []string{"κόσμε"}, 1, true},
+		{"empty chunks", []string{"", "a", ""}, 3, true},
+		{"split 2-byte", []string{"\xce", "\xba"}, 2, true},
+		{"split 3-byte", []string{"\xe1", "\xbd", "\xb9"}, 3, true},
+		{"split 4-byte", []string{"\xf0\x9f", "\x98\x80"}, 2, true},
+		{"split after text", []string{"abc\xf0\x9f\x98", "\x80def"}, 2, true},
+		{"incomplete at end", []string{"abc\xe1\xbd"}, 1, false},
+		{"max code point", []string{"\xf4\x8f\xbf\xbf"}, 1, true},
+		{"surrogate", []string{"\xed\xa0\x80"}, 0, true},
+		{"surrogate prefix", []string{"\xed\xa0"}, 0, true},
+		{"split surrogate", []string{"\xed", "\xa0"}, 1, false},
+		{"above max code point", []string{"\xf4\x90\x80\x80"}, 0, true},
+		{"above max code point prefix", []string{"\xf4\x90"}, 0, true},
+		{"split above max code point", []string{"κόσμε\xf4", "\x90", "\x80\x80edited"}, 1, false},
+		{"overlong", []string{"\xc0\xaf"}, 0, true},
+		{"overlong 3-byte prefix", []string{"\xe0\x80"}, 0, true},
+		{"invalid byte", []string{"\xff"}, 0, true},
+		{"unexpected continuation", []string{"a", "\x80"}, 1, true},
+		{"missing continuation", []string{"\xce", "a"}, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v utf8Validator
+			valid := 0
+			for _, chunk := range tt.chunks {
+				if !v.validate([]byte(chunk)) {
+					break
+				}
+				valid++
+			}
+
+			if valid != tt.valid {
+				t.Fatalf("validate accepted %d chunks, want %d", valid, tt.valid)
+			}
+
+			if valid == len(tt.chunks) && v.complete() != tt.complete {
+				t.Fatalf("complete: got %v, want %v", v.complete(), tt.complete)
+			}
+		})
+	}
+}
+
+// TestUTF8FailFast sends the fragments of the Autobahn 6.4.x cases, the
+// message must be rejected before its last fragment arrives.
+func TestUTF8FailFast(t *testing.T) {
+	tests := []struct {
+		name      string
+		fragments []string // all but the last fragment of the message
+		chopped   bool     // the frames are written byte by byte
+	}{
+		{"6.4.1", []string{"κόσμε", "\xf4\x90\x80\x80"}, false},
+		{"6.4.2", []string{"κόσμε\xf4", "\x90"}, false},
+		{"6.4.3", []string{"κόσμε", "\xf4\x90\x80\x80"}, true},
+		{"6.4.4", []string{"κόσμε\xf4", "\x90"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			defer b.Close()
+
+			c := newConn(a, false, nil, nil)
+			defer c.Close()
+
+			go func() {
+				for i, p := range tt.fragments {
+					opcode := continuation
+					if i == 0 {
+						opcode = TextMsg
+					}
+
+					var buf bytes.Buffer
+					WriteFrame(&buf, true, &Frame{Opcode: opcode, Payload: []byte(p)})
+
+					chunk := buf.Len()
+					if tt.chopped {
+						chunk = 1
+					}
+
+					for buf.Len() > 0 {
+						if _, err := b.Write(buf.Next(chunk)); err != nil {
+							// the connection was failed before the rest of
+							// the frame was written.
+							return
+						}
+					}
+				}
+			}()
+
+			closeCode := make(chan int, 1)
+			go func() {
+				defer close(closeCode)
+
+				f, err := ReadFrame(b, true)
+				if err != nil || f.Opcode != CloseMsg || len(f.Payload) < 2 {
+					return
+				}
+				closeCode <- int(binary.BigEndian.Uint16(f.Payload))
+			}()
+
+			_, r, err := c.NextReader()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = io.ReadAll(r)
+			var ce *CloseError
+			if !errors.As(err, &ce) || ce.Code != StatusInvalidFramePayloadData {
+				t.Fatalf("got %v, want a close error with status %d", err, StatusInvalidFramePayloadData)
+			}
+
+			if code := <-closeCode; code != StatusInvalidFramePayloadData {
+				t.Fatalf("the peer received close code %d, want %d", code, StatusInvalidFramePayloadData)
+			}
+		})
+	}
+}
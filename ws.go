@@ -6,14 +6,24 @@ package bisoc
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 )
 
+// CloseError is returned by the reading methods of a [Conn] when a close
+// frame is received from the peer, or when the peer violates the protocol.
+// In the latter case a close frame carrying Code and Reason is sent to the
+// peer before the error is returned.
 type CloseError struct {
 	Code   int    // Status code of error
 	Reason string // Optional Reason for error
@@ -62,14 +72,6 @@ func isDataFrame(i int) bool {
 	return i == TextMsg || i == BinMsg
 }
 
-func validateRSV(b byte) error {
-	if b&(rsv1|rsv2|rsv3) != 0 {
-		return &CloseError{Code: StatusProtocolError, Reason: "rsv bits are set but not negotiated"}
-	}
-
-	return nil
-}
-
 func isValidCloseCode(code int) bool {
 	switch code {
 	case StatusNormalClosure,
@@ -90,20 +92,57 @@ func isValidCloseCode(code int) bool {
 }
 
 // Conn represents a WebSocket connection.
-// This websocket connection is not thread-safe,
-// ensure all the methods being called from a single
-// thread/goroutine.
+//
+// A Conn supports one concurrent reader and any number of concurrent
+// writers. The reading methods (NextReader, RecvMsg, SetReadLimit) must
+// be called from a single goroutine, the handlers attached with OnClose,
+// OnPing and OnPong are called from it too. The writing methods
+// (NextWriter, SendMsg) can be called from any goroutine, messages are
+// sent one at a time while control frames are sent in between the
+// fragments of a message. CloseWithCode and Close can be called from
+// any goroutine.
+//
+// A connection is open until a close frame is sent, after which nothing
+// else can be written to it, and is closed once the underlying network
+// connection is closed.
 type Conn struct {
-	conn         net.Conn
-	client       bool
-	subprotocol  string
-	writeBuf     []byte // this has a minimum size of atleast minBufSize (512 bytes)
-	br           *bufio.Reader
-	readLimit    int
-	reader       io.Reader
-	closeHandler func(int, string) error
-	pingHandler  func(string) error
-	pongHandler  func(string) error
+	conn           net.Conn
+	client         bool
+	subprotocol    string
+	handshake      HandshakeInfo // immutable once the connection is established
+	writeBuf       []byte        // this has a minimum size of atleast minBufSize (512 bytes)
+	msgLock        chan struct{} // held by the writer of a data message, it guards writeBuf
+	writeMu        sync.Mutex    // held while writing a frame to conn
+	closeSent      atomic.Bool   // set under writeMu once a close frame is written
+	closed         atomic.Bool   // set once the underlying connection is closed
+	closeRecv      chan struct{} // closed once a close frame is received
+	closeTimeout   time.Duration
+	done           chan struct{}              // closed once the underlying connection is closed
+	abortErr       atomic.Pointer[CloseError] // reason for closing the connection outside the reader
+	keepalive      bool
+	lastRead       atomic.Int64 // unix time in nanoseconds
+	pingSent       atomic.Int64 // unix time in nanoseconds of the unanswered keepalive ping
+	rtt            atomic.Int64
+	readMu         sync.Mutex // held while reading from br
+	br             *bufio.Reader
+	readLimit      int
+	readErr        error         // first error while reading, it is returned by all later reads
+	readDone       chan struct{} // closed once readErr is set
+	onClose        func()        // called once the underlying connection is closed
+	firstMessage   atomic.Bool   // a read deadline bounds the first message
+	reader         io.Reader
+	frameContinues bool        // a message read with ReadFrame continues in the next frame
+	frameMsgSize   int         // size of the message read with ReadFrame so far
+	frameWriting   atomic.Bool // a message is written with WriteFrame, msgLock is held
+	extensions     []ExtensionTransform
+	rsv            byte // rsv bits claimed by the negotiated extensions
+	queueSize      int
+	queuePolicy    QueuePolicy
+	queueMu        sync.Mutex                // held while creating the queue
+	queue          atomic.Pointer[sendQueue] // created by the first Enqueue
+	closeHandler   func(int, string) error
+	pingHandler    func(string) error
+	pongHandler    func(string) error
 }
 
 // newConn creates a new WebSocket connection [Conn].
@@ -117,11 +156,16 @@ func newConn(conn net.Conn, isClient bool, br *bufio.Reader, writeBuf []byte) *C
 	}
 
 	c := &Conn{
-		conn:      conn,
-		client:    isClient,
-		br:        br,
-		writeBuf:  writeBuf,
-		readLimit: ReadLimit,
+		conn:         conn,
+		client:       isClient,
+		br:           br,
+		writeBuf:     writeBuf,
+		msgLock:      make(chan struct{}, 1),
+		closeRecv:    make(chan struct{}),
+		done:         make(chan struct{}),
+		readDone:     make(chan struct{}),
+		closeTimeout: CloseTimeout,
+		readLimit:    ReadLimit,
 	}
 
 	c.OnClose(nil)
@@ -145,6 +189,7 @@ type msgReader struct {
 func (mr *msgReader) Read(p []byte) (int, error) {
 	if mr.remain == 0 {
 		if mr.eof {
+			mr.c.messageRead()
 			return 0, io.EOF
 		}
 
@@ -166,13 +211,11 @@ func (mr *msgReader) Read(p []byte) (int, error) {
 		}
 
 		if mr.mask != nil {
-			for i := range n {
-				p[i] ^= mr.mask[mr.maskPos&3]
-				mr.maskPos++
-			}
+			mr.maskPos = maskBytes(mr.mask, mr.maskPos, p[:n])
 		}
 
 		mr.remain -= n
+		mr.c.touch()
 	}
 
 	return n, err
@@ -184,14 +227,15 @@ func (mr *msgReader) readFrame() error {
 		if err != nil {
 			return err
 		}
-
-		if err := validateRSV(header[0]); err != nil {
-			return err
-		}
+		mr.c.touch()
 
 		opcode := int(header[0] & 0x0F)
 		final := (header[0] & fin) != 0
 
+		if err := mr.c.validateRSV(header[0], false); err != nil {
+			return err
+		}
+
 		if isControlFrame(opcode) {
 			if !final {
 				return &CloseError{Code: StatusProtocolError, Reason: "fin bit not set in control frame"}
@@ -233,9 +277,15 @@ func (mr *msgReader) readFrame() error {
 	}
 }
 
+// msgWriter helps write a message to a connection as fragmented frames,
+// the payload is buffered in writeBuf after the space reserved for the
+// frame header.
 type msgWriter struct {
 	c      *Conn
 	opcode int
+	rsv    byte  // rsv bits of the first frame
+	pos    int   // end of the buffered payload in writeBuf
+	err    error // first error while writing a frame
 	closed bool
 }
 
@@ -244,22 +294,23 @@ func (mw *msgWriter) Write(p []byte) (int, error) {
 		return 0, errInvalidWrite
 	}
 
+	if mw.err != nil {
+		return 0, mw.err
+	}
+
 	total := 0
-	maxPayloadSize := len(mw.c.writeBuf) - 14
 	for len(p) > 0 {
-		n := min(len(p), maxPayloadSize)
-
-		chunk := p[:n]
-		p = p[n:]
-
-		// send first fragment with FIN = 0
-		if err := mw.c.writeFrame(mw.opcode, false, chunk); err != nil {
-			return total, err
+		if mw.pos == len(mw.c.writeBuf) {
+			// buffer is full, send a fragment with FIN = 0
+			if err := mw.flushFrame(false); err != nil {
+				return total, err
+			}
 		}
 
+		n := copy(mw.c.writeBuf[mw.pos:], p)
+		mw.pos += n
+		p = p[n:]
 		total += n
-		// after the first frame, all subsequent fragments must be continuations
-		mw.opcode = continuation
 	}
 
 	return total, nil
@@ -271,107 +322,385 @@ func (mw *msgWriter) Close() error {
 	}
 
 	mw.closed = true
-	return mw.c.writeFrame(mw.opcode, true, nil)
+	if mw.err == nil {
+		mw.err = mw.flushFrame(true)
+	}
+
+	// release writeBuf for the next message
+	<-mw.c.msgLock
+	return mw.err
+}
+
+// flushFrame writes the buffered payload as a single frame.
+func (mw *msgWriter) flushFrame(final bool) error {
+	ws := mw.c
+	payload := ws.writeBuf[maxFrameHeaderSize:mw.pos]
+
+	var header [maxFrameHeaderSize]byte
+	n := ws.encodeHeader(header[:], mw.opcode, mw.rsv, final, payload)
+
+	// place the header right before the payload
+	start := maxFrameHeaderSize - n
+	copy(ws.writeBuf[start:], header[:n])
+
+	// after the first frame, all subsequent fragments must be continuations
+	mw.opcode = continuation
+	mw.rsv = 0
+	mw.pos = maxFrameHeaderSize
+
+	err := ws.writeRaw(ws.writeBuf[start:maxFrameHeaderSize+len(payload)], false)
+	if err != nil {
+		mw.err = err
+	}
+
+	return err
+}
+
+// NextWriter returns a writer for the next message to send, msgKind must be
+// [TextMsg] or [BinMsg]. The message is sent as one or more frames, and is
+// complete once the writer is closed.
+//
+// Only one message is written at a time, NextWriter blocks until the writer
+// of the previous message is closed, so every writer must be closed even if
+// writing to it fails.
+func (ws *Conn) NextWriter(msgKind int) (io.WriteCloser, error) {
+	return ws.nextWriter(context.Background(), msgKind)
+}
+
+// nextWriter is NextWriter which stops waiting for the previous writer once
+// ctx is done.
+func (ws *Conn) nextWriter(ctx context.Context, msgKind int) (io.WriteCloser, error) {
+	if !isDataFrame(msgKind) {
+		return nil, errInvalidMsgKind
+	}
+
+	if err := ws.writeState(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case ws.msgLock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	mw := &msgWriter{
+		c:      ws,
+		opcode: msgKind,
+		pos:    maxFrameHeaderSize,
+	}
+
+	return ws.extensionWriter(mw), nil
 }
 
 // Sends a single websocket message to the connected peer.
 func (ws *Conn) SendMsg(msgKind int, data string) error {
-	// control messages are directly written to the underlying tcp connection
-	// as they cannot be fragmented
+	return ws.SendMsgContext(context.Background(), msgKind, data)
+}
+
+// SendMsgContext is like SendMsg, but gives up once ctx is done.
+//
+// If ctx is done while waiting for the writer of the previous message, the
+// connection is left untouched and ctx.Err() is returned. If ctx is done
+// while the message is being written, a frame may have been written only
+// partially, so the connection is closed and ctx.Err() is returned. Writes
+// of other goroutines pending at that time fail as well. If ctx is done just
+// after the message was written, nil is returned and the write deadline of
+// the connection is cleared.
+func (ws *Conn) SendMsgContext(ctx context.Context, msgKind int, data string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var w io.WriteCloser
 	if isControlFrame(msgKind) {
 		if len(data) > maxControlFramePayloadSize {
 			return &CloseError{Code: StatusInvalidFramePayloadData, Reason: "control frame payload data too big"}
 		}
+	} else {
+		var err error
+		w, err = ws.nextWriter(ctx, msgKind)
+		if err != nil {
+			return err
+		}
+	}
 
-		return ws.writeFrame(msgKind, true, []byte(data))
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		ws.conn.SetWriteDeadline(aLongTimeAgo)
+		close(interrupted)
+	})
+
+	var err error
+	if w == nil {
+		// control messages are directly written to the underlying tcp connection
+		// as they cannot be fragmented
+		err = ws.writeControl(msgKind, []byte(data))
+	} else {
+		_, err = w.Write([]byte(data))
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
 	}
 
-	mw := &msgWriter{
-		c:      ws,
-		opcode: msgKind,
+	if !stop() {
+		<-interrupted
+		if err == nil {
+			// the message was written before the write was interrupted.
+			ws.conn.SetWriteDeadline(time.Time{})
+			return nil
+		}
+
+		ws.Close()
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// writeControl writes a control frame, it does not use writeBuf so a
+// control frame can be sent between the fragments of a message.
+func (ws *Conn) writeControl(opcode int, payload []byte) error {
+	var buf [maxFrameHeaderSize + maxControlFramePayloadSize]byte
+
+	// control frames always have a 2 bytes long header before the masking key
+	n := 2
+	if ws.client {
+		n += 4
 	}
+	copy(buf[n:], payload)
+	ws.encodeHeader(buf[:], opcode, 0, true, buf[n:n+len(payload)])
+
+	return ws.writeRaw(buf[:n+len(payload)], opcode == CloseMsg)
+}
+
+// writeRaw writes an encoded frame to the connection, nothing can be
+// written after a close frame, RFC 6455 (Section 5.5.1).
+func (ws *Conn) writeRaw(b []byte, closeFrame bool) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
 
-	if _, err := mw.Write([]byte(data)); err != nil {
+	if err := ws.writeState(); err != nil {
 		return err
 	}
 
-	return mw.Close()
+	if closeFrame {
+		ws.closeSent.Store(true)
+	}
+
+	_, err := ws.conn.Write(b)
+	return err
 }
 
-func (ws *Conn) writeFrame(opcode int, final bool, payload []byte) error {
-	b0 := byte(opcode)
+// writeState returns the error for writing to the connection in its current state.
+func (ws *Conn) writeState() error {
+	switch {
+	case ws.closed.Load():
+		return ErrClosed
+	case ws.closeSent.Load():
+		return ErrCloseSent
+	}
+
+	return nil
+}
+
+// encodeHeader encodes the header of a frame into b, which must have a size
+// of atleast maxFrameHeaderSize, and returns the size of the header. A client
+// also masks the payload in place with a new masking key.
+func (ws *Conn) encodeHeader(b []byte, opcode int, rsv byte, final bool, payload []byte) int {
+	n := putHeader(b, opcode, rsv, final, len(payload), ws.client)
+
+	if ws.client {
+		maskKey := newMaskKey()
+		copy(b[n:], maskKey[:])
+		n += 4
+		maskBytes(maskKey[:], 0, payload)
+	}
+
+	return n
+}
+
+// putHeader encodes the header of a frame with a payload of length l into b
+// and returns its size, without the masking key.
+func putHeader(b []byte, opcode int, rsv byte, final bool, l int, isMasked bool) int {
+	b0 := byte(opcode) | rsv
 	if final {
 		b0 |= fin
 	}
-	ws.writeBuf[0] = b0
+	b[0] = b0
 
-	l := len(payload)
 	b1 := byte(0)
-	if ws.client {
+	if isMasked {
 		b1 |= masked
 	}
 
-	headerBytes := 2
+	n := 2
 	switch {
 	case l >= 65536:
 		b1 |= 127
-		binary.BigEndian.PutUint64(ws.writeBuf[2:10], uint64(l))
-		ws.writeBuf[1] = b1
-		headerBytes = 10
+		binary.BigEndian.PutUint64(b[2:10], uint64(l))
+		n = 10
 	case l > 125:
 		b1 |= 126
-		binary.BigEndian.PutUint16(ws.writeBuf[2:4], uint16(l))
-		ws.writeBuf[1] = b1
-		headerBytes = 4
+		binary.BigEndian.PutUint16(b[2:4], uint16(l))
+		n = 4
 	default:
 		b1 |= byte(l)
-		ws.writeBuf[1] = b1
 	}
+	b[1] = b1
 
-	maskKey := newMaskKey()
-	if ws.client {
-		copy(ws.writeBuf[headerBytes:], maskKey[:])
-		headerBytes += 4
+	return n
+}
+
+// maskBytes applies the masking key to b starting at position pos of the
+// payload and returns the next position, RFC 6455 (Section 5.3).
+func maskBytes(key []byte, pos int, b []byte) int {
+	for i := range b {
+		b[i] ^= key[(pos+i)&3]
 	}
 
-	if ws.client {
-		for i := range payload {
-			ws.writeBuf[headerBytes+i] = payload[i] ^ maskKey[i&3]
+	return pos + len(b)
+}
+
+// NextReader returns the type and a reader for the next data message received
+// from the peer, control frames are handled while waiting for the message.
+// The reader returns [io.EOF] at the end of the message, and the rest of the
+// message is discarded when NextReader is called again.
+//
+// Once reading fails, the connection is broken and the same error is
+// returned by all later reads, also by the reader of a message which was
+// cut off, e.g. by CloseWithCode.
+func (ws *Conn) NextReader() (int, io.Reader, error) {
+	ws.readMu.Lock()
+	defer ws.readMu.Unlock()
+
+	opcode, r, err := ws.nextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return opcode, &lockedReader{c: ws, r: r}, nil
+}
+
+// lockedReader is the reader returned by NextReader, it holds readMu while
+// reading so that a concurrent CloseWithCode can tell if a read is pending.
+type lockedReader struct {
+	c *Conn
+	r io.Reader
+}
+
+func (lr *lockedReader) Read(p []byte) (int, error) {
+	ws := lr.c
+	ws.readMu.Lock()
+	defer ws.readMu.Unlock()
+
+	if ws.readErr != nil {
+		// the connection failed or was closed, e.g. by CloseWithCode which
+		// discarded the rest of the message, it is not reported as complete.
+		return 0, ws.readErr
+	}
+
+	if ws.reader != lr.r {
+		// the rest of the message was discarded by a later NextReader.
+		return 0, io.EOF
+	}
+
+	n, err := lr.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = ws.readFail(err)
+	}
+
+	return n, err
+}
+
+// readFail records the first error while reading from the connection.
+func (ws *Conn) readFail(err error) error {
+	if ce := ws.abortErr.Load(); ce != nil {
+		// the connection was closed by the keepalive.
+		err = ce
+	}
+
+	if ws.readErr != nil {
+		return err
+	}
+
+	ws.readErr = err
+	close(ws.readDone)
+
+	var ce *CloseError
+	if errors.As(err, &ce) && !ws.closeReceived() {
+		// RFC 6455 (Section 7.1.7)
+		//
+		// If _The WebSocket Connection is Established_ prior to the point
+		// where the endpoint is required to _Fail the WebSocket Connection_,
+		// the endpoint SHOULD send a Close frame with an appropriate status
+		// code before proceeding to _Close the WebSocket Connection_.
+		reason := ce.Reason
+		if len(reason) > maxControlFramePayloadSize-2 {
+			reason = strings.ToValidUTF8(reason[:maxControlFramePayloadSize-2], "")
+		}
+
+		if p, err := formatCloseMessage(ce.Code, reason); err == nil {
+			ws.writeControl(CloseMsg, p)
 		}
-	} else {
-		copy(ws.writeBuf[headerBytes:], payload)
 	}
 
-	_, err := ws.conn.Write(ws.writeBuf[:headerBytes+l])
 	return err
 }
 
-// Receives a single websocket message from the connected peer
-func (ws *Conn) RecvMsg() (int, []byte, error) {
+// closeReceived reports whether a close frame was received from the peer.
+func (ws *Conn) closeReceived() bool {
+	select {
+	case <-ws.closeRecv:
+		return true
+	default:
+		return false
+	}
+}
+
+// nextReader is NextReader without locking readMu.
+func (ws *Conn) nextReader() (int, io.Reader, error) {
+	if ws.readErr != nil {
+		return 0, nil, ws.readErr
+	}
+
 	// clean leftovers
 	if ws.reader != nil {
 		_, err := io.Copy(io.Discard, ws.reader)
 		if err != nil {
-			return 0, nil, err
+			return 0, nil, ws.readFail(err)
 		}
 
 		ws.reader = nil
 	}
 
+	opcode, r, err := ws.readMsgStart()
+	if err != nil {
+		return 0, nil, ws.readFail(err)
+	}
+
+	ws.reader = r
+	return opcode, r, nil
+}
+
+// readMsgStart reads frames until the first frame of a data message and
+// returns a reader for the message, control frames are handled on the way.
+func (ws *Conn) readMsgStart() (int, io.Reader, error) {
 	for {
 		// parse the first two bytes of frame header
 		header, err := ws.readHeader(2)
 		if err != nil {
 			return 0, nil, err
 		}
-
-		if err := validateRSV(header[0]); err != nil {
-			return 0, nil, err
-		}
+		ws.touch()
 
 		final := (header[0] & fin) != 0
 		opcode := int(header[0] & 0x0F)
 
+		if err := ws.validateRSV(header[0], isDataFrame(opcode)); err != nil {
+			return 0, nil, err
+		}
+
 		if isControlFrame(opcode) {
 			if !final {
 				return 0, nil, &CloseError{Code: StatusProtocolError, Reason: "fin bit not set in control frame"}
@@ -398,44 +727,169 @@ func (ws *Conn) RecvMsg() (int, []byte, error) {
 			return 0, nil, err
 		}
 
-		ws.reader = &msgReader{
+		r := ws.extensionReader(&msgReader{
 			c:      ws,
 			eof:    final,
 			remain: int(l),
 			mask:   mask,
-		}
+		}, header[0])
 
-		payload, err := io.ReadAll(ws.reader)
-		ws.reader = nil
-		if err != nil {
-			return 0, nil, err
+		if opcode == TextMsg {
+			r = &utf8Reader{r: r}
 		}
 
-		// RFC 6455 (Section 8.1)
-		//
-		// When an endpoint is to interpret a byte stream as UTF-8 but finds
-		// that the byte stream is not, in fact, a valid UTF-8 stream, that
-		// endpoint must fail the connection.
-		//
-		// Implementation Note: I am only validating this at message boundary
-		// and not at every chunk/frame due to induced complexity of the procedure
-		// which is infact is not strictly inforced by the standard.
-		if opcode == TextMsg && !utf8.Valid(payload) {
-			return 0, nil, &CloseError{
-				Code:   StatusInvalidFramePayloadData,
-				Reason: "invalid utf8 encoded text",
-			}
+		return opcode, r, nil
+	}
+}
+
+// Receives a single websocket message from the connected peer
+func (ws *Conn) RecvMsg() (int, []byte, error) {
+	ws.readMu.Lock()
+	defer ws.readMu.Unlock()
+
+	return ws.recvMsg()
+}
+
+// RecvMsgContext is like RecvMsg, but gives up once ctx is done.
+//
+// If ctx is done before the message is read completely, the read may have
+// stopped in the middle of a frame, so the connection is closed and ctx.Err()
+// is returned by this and all later reads. If ctx is done just after the
+// message was read, the message is returned and the read deadline of the
+// connection is cleared.
+func (ws *Conn) RecvMsgContext(ctx context.Context) (int, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	ws.readMu.Lock()
+	defer ws.readMu.Unlock()
+
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		ws.conn.SetReadDeadline(aLongTimeAgo)
+		close(interrupted)
+	})
+
+	opcode, payload, err := ws.recvMsg()
+	if !stop() {
+		<-interrupted
+		if err == nil {
+			// the message was read before the read was interrupted.
+			ws.conn.SetReadDeadline(time.Time{})
+			return opcode, payload, nil
 		}
 
-		return opcode, payload, nil
+		// the interrupted read is reported as the context error.
+		ws.readFail(ctx.Err())
+		ws.readErr = ctx.Err()
+		ws.Close()
+		return 0, nil, ws.readErr
+	}
+
+	return opcode, payload, err
+}
+
+// recvMsg is RecvMsg without locking readMu.
+func (ws *Conn) recvMsg() (int, []byte, error) {
+	opcode, r, err := ws.nextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// text messages are validated while reading, see utf8Reader.
+	payload, err := io.ReadAll(r)
+	ws.reader = nil
+	if err != nil {
+		return 0, nil, ws.readFail(err)
+	}
+
+	return opcode, payload, nil
+}
+
+// setExtensions sets the negotiated extensions of the connection.
+func (ws *Conn) setExtensions(ts []ExtensionTransform) {
+	ws.extensions = ts
+	ws.rsv = 0
+	for _, t := range ts {
+		ws.rsv |= t.RSV() & (RSV1 | RSV2 | RSV3)
 	}
 }
 
+// extensionReader applies the negotiated extensions to the payload of an
+// incoming message, in the reverse order of their negotiation.
+func (ws *Conn) extensionReader(mr *msgReader, b0 byte) io.Reader {
+	if len(ws.extensions) == 0 {
+		return mr
+	}
+
+	var r io.Reader = mr
+	for i := len(ws.extensions) - 1; i >= 0; i-- {
+		ext := ws.extensions[i]
+		r = ext.NewReader(r, b0&ext.RSV())
+	}
+
+	// the transformed message must still be within the read limit.
+	return &limitReader{r: r, remain: ws.readLimit}
+}
+
+// extensionWriter applies the negotiated extensions to the payload of an
+// outgoing message, in the order of their negotiation.
+func (ws *Conn) extensionWriter(mw *msgWriter) io.WriteCloser {
+	var w io.WriteCloser = mw
+	for i := len(ws.extensions) - 1; i >= 0; i-- {
+		ext := ws.extensions[i]
+		var rsv byte
+		w, rsv = ext.NewWriter(w, mw.opcode)
+		mw.rsv |= rsv & ext.RSV()
+	}
+
+	return w
+}
+
+// validateRSV checks the rsv bits of a frame header against the negotiated
+// extensions, claimed bits may only be set on the first frame of a data
+// message.
+func (ws *Conn) validateRSV(b byte, first bool) error {
+	allowed := byte(0)
+	if first {
+		allowed = ws.rsv
+	}
+
+	if b&(RSV1|RSV2|RSV3)&^allowed != 0 {
+		return &CloseError{Code: StatusProtocolError, Reason: "rsv bits are set but not negotiated"}
+	}
+
+	return nil
+}
+
+// limitReader fails with [StatusMessageTooBig] when more than remain bytes
+// are read, it bounds the size of a message after decompression.
+type limitReader struct {
+	r      io.Reader
+	remain int
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.remain -= n
+	if lr.remain < 0 {
+		return n, &CloseError{Code: StatusMessageTooBig}
+	}
+
+	return n, err
+}
+
 // readHeader reads n bytes from the underlying connection
 func (ws *Conn) readHeader(n int) ([]byte, error) {
-	header := make([]byte, n)
-	_, err := io.ReadFull(ws.br, header)
-	return header, err
+	return readBytes(ws.br, n)
+}
+
+// readBytes reads n bytes from r
+func readBytes(r io.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
 }
 
 // readControlPayload reads the payload section for a control frame
@@ -455,9 +909,7 @@ func (ws *Conn) readControlPayload(header []byte) ([]byte, error) {
 	}
 
 	if mask != nil {
-		for i := range p {
-			p[i] ^= mask[i&3]
-		}
+		maskBytes(mask, 0, p)
 	}
 
 	return p, nil
@@ -465,39 +917,45 @@ func (ws *Conn) readControlPayload(header []byte) ([]byte, error) {
 
 // readExtensions reads the extended header fields
 func (ws *Conn) readExtensions(header []byte) (uint64, []byte, error) {
+	return readExtensions(ws.br, header, ws.client, ws.readLimit)
+}
+
+// readExtensions reads the extended header fields of a frame from r, which is
+// read by a client if isClient is true.
+func readExtensions(r io.Reader, header []byte, isClient bool, limit int) (uint64, []byte, error) {
 	l := uint64(header[1] & 0x7F)
 
 	switch l {
 	case 126:
-		ext, err := ws.readHeader(2)
+		ext, err := readBytes(r, 2)
 		if err != nil {
 			return 0, nil, err
 		}
 		l = uint64(binary.BigEndian.Uint16(ext))
 	case 127:
-		ext, err := ws.readHeader(8)
+		ext, err := readBytes(r, 8)
 		if err != nil {
 			return 0, nil, err
 		}
 		l = binary.BigEndian.Uint64(ext)
 	}
 
-	if l > uint64(ws.readLimit) {
+	if l > uint64(limit) {
 		return 0, nil, &CloseError{Code: StatusMessageTooBig}
 	}
 
 	ismasked := (header[1] & masked) != 0
 
-	if !ws.client && !ismasked {
+	if !isClient && !ismasked {
 		return 0, nil, &CloseError{Code: StatusProtocolError, Reason: "client must mask all frames that it sends to the server"}
 	}
 
 	if ismasked {
-		if ws.client {
+		if isClient {
 			return 0, nil, &CloseError{Code: StatusProtocolError, Reason: "server must not mask any frames that it sends to the client"}
 		}
 
-		mask, err := ws.readHeader(4)
+		mask, err := readBytes(r, 4)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -542,17 +1000,20 @@ func (ws *Conn) handleControlFrame(opcode int, p []byte) error {
 			}
 		}
 
+		close(ws.closeRecv)
 		ws.closeHandler(code, string(p))
 		return &CloseError{Code: code, Reason: reason}
 	case PingMsg:
 		return ws.pingHandler(string(p))
 	default:
+		ws.keepalivePong(p)
 		return ws.pongHandler(string(p))
 	}
 }
 
 // attaches a closeHandler to the connection, default behaviour
-// is to send a close frame in response with the same status code
+// is to send a close frame in response with the same status code,
+// unless a close frame was already sent
 func (ws *Conn) OnClose(f func(code int, body string) error) {
 	if f == nil {
 		f = func(_ int, body string) error {
@@ -562,7 +1023,11 @@ func (ws *Conn) OnClose(f func(code int, body string) error) {
 			// Close frame, the endpoint MUST send a Close frame in response.
 			// (When sending a Close frame in response, the endpoint typically
 			// echos the status code it received.)
-			return ws.SendMsg(CloseMsg, body)
+			if err := ws.SendMsg(CloseMsg, body); err != ErrCloseSent {
+				return err
+			}
+
+			return nil
 		}
 	}
 
@@ -574,7 +1039,12 @@ func (ws *Conn) OnClose(f func(code int, body string) error) {
 func (ws *Conn) OnPing(f func(appData string) error) {
 	if f == nil {
 		f = func(appData string) error {
-			return ws.SendMsg(PongMsg, appData)
+			// no pong is sent once the closing handshake has started.
+			if err := ws.SendMsg(PongMsg, appData); err != ErrCloseSent {
+				return err
+			}
+
+			return nil
 		}
 	}
 
@@ -596,18 +1066,55 @@ func (ws *Conn) Subprotocol() string {
 	return ws.subprotocol
 }
 
+// TLSConnectionState returns the state of the TLS connection, ok is false if
+// the connection does not use TLS.
+func (ws *Conn) TLSConnectionState() (state tls.ConnectionState, ok bool) {
+	if ws.handshake.TLS == nil {
+		return tls.ConnectionState{}, false
+	}
+
+	return *ws.handshake.TLS, true
+}
+
 func (ws *Conn) SetReadLimit(limit int) {
 	ws.readLimit = limit
 }
 
+// SetCloseTimeout sets the duration CloseWithCode waits for the peer's
+// close frame, the default is [CloseTimeout].
+func (ws *Conn) SetCloseTimeout(d time.Duration) {
+	ws.closeTimeout = d
+}
+
 func (ws *Conn) SetDeadline(t time.Time) error {
+	ws.firstMessage.Store(false)
 	return ws.conn.SetDeadline(t)
 }
 
 func (ws *Conn) SetReadDeadline(t time.Time) error {
+	ws.firstMessage.Store(false)
 	return ws.conn.SetReadDeadline(t)
 }
 
+// setFirstMessageTimeout bounds the duration until the first message was
+// read with a read deadline.
+func (ws *Conn) setFirstMessageTimeout(d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	ws.firstMessage.Store(true)
+	return ws.conn.SetReadDeadline(time.Now().Add(d))
+}
+
+// messageRead removes the read deadline of the first message once a message
+// was read completely.
+func (ws *Conn) messageRead() {
+	if ws.firstMessage.CompareAndSwap(true, false) {
+		ws.conn.SetReadDeadline(time.Time{})
+	}
+}
+
 func (ws *Conn) SetWriteDeadline(t time.Time) error {
 	return ws.conn.SetWriteDeadline(t)
 }
@@ -620,7 +1127,105 @@ func (ws *Conn) RemoteAddr() net.Addr {
 	return ws.conn.RemoteAddr()
 }
 
-// Close closes the underlying tcp connection.
+// CloseWithCode performs the closing handshake described in RFC 6455
+// (Section 7.1.2). It sends a close frame with the status code and reason,
+// waits for the peer's close frame while discarding any data messages, and
+// then closes the underlying connection.
+//
+// If another goroutine is reading from the connection, the peer's close
+// frame is received by that reader, which sees it as a [*CloseError].
+// CloseWithCode waits for at most the close timeout before closing the
+// connection anyway.
+//
+// [StatusNoStatusReceived] sends a close frame without a status code.
+func (ws *Conn) CloseWithCode(code int, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ws.closeTimeout)
+	defer cancel()
+
+	return ws.closeHandshake(ctx, code, reason)
+}
+
+// closeHandshake is CloseWithCode which waits for the peer's close frame
+// until ctx is done.
+func (ws *Conn) closeHandshake(ctx context.Context, code int, reason string) error {
+	p, err := formatCloseMessage(code, reason)
+	if err != nil {
+		return err
+	}
+
+	err = ws.writeControl(CloseMsg, p)
+	if err == ErrClosed {
+		return err
+	}
+
+	if err != nil && err != ErrCloseSent {
+		ws.Close()
+		return err
+	}
+
+	if ws.readMu.TryLock() {
+		// No reader is pending, read until the peer's close frame arrives.
+		stop := context.AfterFunc(ctx, func() {
+			ws.conn.SetReadDeadline(aLongTimeAgo)
+		})
+
+		for {
+			_, r, err := ws.nextReader()
+			if err != nil {
+				break
+			}
+
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				ws.readFail(err)
+				break
+			}
+		}
+		stop()
+
+		err := ws.Close()
+		ws.readMu.Unlock()
+		return err
+	}
+
+	select {
+	case <-ws.closeRecv:
+	case <-ctx.Done():
+	}
+
+	return ws.Close()
+}
+
+// formatCloseMessage formats the body of a close frame, RFC 6455 (Section 5.5.1).
+func formatCloseMessage(code int, reason string) ([]byte, error) {
+	if code == StatusNoStatusReceived {
+		return nil, nil
+	}
+
+	if !isValidCloseCode(code) {
+		return nil, errInvalidCloseCode
+	}
+
+	if len(reason) > maxControlFramePayloadSize-2 {
+		return nil, errCloseReasonTooLong
+	}
+
+	p := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(p, uint16(code))
+	copy(p[2:], reason)
+	return p, nil
+}
+
+// Close closes the underlying tcp connection without sending a close
+// frame, use CloseWithCode to perform the closing handshake.
 func (ws *Conn) Close() error {
+	if ws.closed.Swap(true) {
+		return ErrClosed
+	}
+	close(ws.done)
+
+	if ws.onClose != nil {
+		ws.onClose()
+	}
+
 	return ws.conn.Close()
 }
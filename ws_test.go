@@ -0,0 +1,429 @@
+// Copyright 2026 Uday Tiwari. All rights reserved.
+// Use of this source code is governed by MIT
+// license that can be found in the LICENSE file.
+
+package bisoc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer starts an http server accepting WebSocket connections with
+// wss and returns the ws:// url of the server, handler is called with every
+// accepted connection.
+func newTestServer(t *testing.T, wss *Server, handler func(c *Conn)) string {
+	t.Helper()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := wss.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		handler(c)
+	}))
+	t.Cleanup(s.Close)
+
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// echo sends every message received on c back to the peer.
+func echo(c *Conn) {
+	for {
+		kind, p, err := c.RecvMsg()
+		if err != nil {
+			return
+		}
+
+		if err := c.SendMsg(kind, string(p)); err != nil {
+			return
+		}
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	for _, compression := range []*PerMessageDeflate{nil, {}} {
+		u := newTestServer(t, &Server{Compression: compression}, echo)
+
+		c, _, err := (&Dialer{Compression: compression}).Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		const writers, messages = 8, 40
+
+		var wg sync.WaitGroup
+		for g := range writers {
+			wg.Go(func() {
+				b := bytes.Repeat([]byte{'a' + byte(g)}, 1000)
+				for i := range messages {
+					if i%2 == 0 {
+						if err := c.SendMsg(TextMsg, strings.Repeat(string(b), 10)); err != nil {
+							t.Error(err)
+						}
+					} else {
+						// a message of several fragments.
+						w, err := c.NextWriter(BinMsg)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+
+						for range 20 {
+							w.Write(b)
+						}
+
+						if err := w.Close(); err != nil {
+							t.Error(err)
+						}
+					}
+
+					if err := c.SendMsg(PingMsg, "ping"); err != nil {
+						t.Error(err)
+					}
+				}
+			})
+		}
+
+		for range writers * messages {
+			_, p, err := c.RecvMsg()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(p) == 0 || bytes.Count(p, p[:1]) != len(p) {
+				t.Fatalf("messages of different writers are interleaved")
+			}
+		}
+
+		wg.Wait()
+		c.Close()
+	}
+}
+
+func TestControlFramesBetweenFragments(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	c := newConn(a, true, nil, nil)
+	defer c.Close()
+
+	frames := make(chan *Frame, 8)
+	go func() {
+		defer close(frames)
+		for {
+			f, err := ReadFrame(b, false)
+			if err != nil {
+				return
+			}
+			frames <- f
+
+			if f.Opcode == CloseMsg {
+				WriteFrame(b, false, &Frame{Fin: true, Opcode: CloseMsg, Payload: f.Payload})
+				return
+			}
+		}
+	}()
+
+	w, err := c.NextWriter(BinMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fill the write buffer, so that a first fragment is sent.
+	if _, err := w.Write(make([]byte, len(c.writeBuf))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.SendMsg(PongMsg, "pong"); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- c.CloseWithCode(StatusNormalClosure, "bye")
+	}()
+
+	want := []struct {
+		opcode int
+		fin    bool
+	}{
+		{BinMsg, false},
+		{PongMsg, true},
+		{CloseMsg, true},
+	}
+	for _, w := range want {
+		f := <-frames
+		if f == nil || f.Opcode != w.opcode || f.Fin != w.fin {
+			t.Fatalf("got frame %+v, want opcode %d and fin %v", f, w.opcode, w.fin)
+		}
+	}
+
+	// the message can not be completed after the close frame.
+	if err := w.Close(); err != ErrCloseSent && err != ErrClosed {
+		t.Fatalf("closing the writer: got %v, want %v or %v", err, ErrCloseSent, ErrClosed)
+	}
+
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+
+	if f, ok := <-frames; ok {
+		t.Fatalf("unexpected frame %+v after the close frame", f)
+	}
+}
+
+func TestCloseWithCodeUnblocksRecvMsg(t *testing.T) {
+	u := newTestServer(t, &Server{}, echo)
+
+	c, _, err := DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	received := make(chan error, 1)
+	go func() {
+		_, _, err := c.RecvMsg()
+		received <- err
+	}()
+
+	// let RecvMsg block waiting for a message.
+	time.Sleep(10 * time.Millisecond)
+
+	start := time.Now()
+	if err := c.CloseWithCode(StatusNormalClosure, "bye"); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := time.Since(start); d >= CloseTimeout {
+		t.Fatalf("the closing handshake took %v", d)
+	}
+
+	var ce *CloseError
+	if err := <-received; !errors.As(err, &ce) || ce.Code != StatusNormalClosure {
+		t.Fatalf("RecvMsg: got %v, want the close frame of the peer", err)
+	}
+
+	if err := c.SendMsg(TextMsg, "late"); err == nil {
+		t.Fatal("sending after the closing handshake succeeded")
+	}
+}
+
+func TestCloseWithCodeDuringNextReader(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	c := newConn(a, true, nil, nil)
+	defer c.Close()
+
+	// the peer completes the message only once it received the close frame,
+	// then it answers the close frame.
+	go func() {
+		WriteFrame(b, false, &Frame{Opcode: TextMsg, Payload: []byte("hello ")})
+		for {
+			f, err := ReadFrame(b, false)
+			if err != nil {
+				return
+			}
+
+			if f.Opcode == CloseMsg {
+				WriteFrame(b, false, &Frame{Fin: true, Opcode: continuation, Payload: []byte("world")})
+				WriteFrame(b, false, &Frame{Fin: true, Opcode: CloseMsg, Payload: f.Payload})
+				return
+			}
+		}
+	}()
+
+	_, r, err := c.NextReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := make([]byte, len("hello "))
+	if _, err := io.ReadFull(r, p); err != nil {
+		t.Fatal(err)
+	}
+
+	// no Read is pending, so CloseWithCode reads and discards the rest of
+	// the message.
+	if err := c.CloseWithCode(StatusNormalClosure, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var ce *CloseError
+	if _, err := r.Read(p); !errors.As(err, &ce) || ce.Code != StatusNormalClosure {
+		t.Fatalf("reading the cut off message: got %v, want the close error", err)
+	}
+}
+
+// interruptConn cancels a context once an armed read or write returned and
+// waits until the context interrupted the connection with a deadline, so
+// that the context is done just after the operation completed.
+type interruptConn struct {
+	net.Conn
+	cancel      context.CancelFunc
+	armed       atomic.Bool
+	once        sync.Once
+	interrupted chan struct{}
+}
+
+func newInterruptConn(conn net.Conn, cancel context.CancelFunc) *interruptConn {
+	return &interruptConn{Conn: conn, cancel: cancel, interrupted: make(chan struct{})}
+}
+
+func (c *interruptConn) interrupt() {
+	if c.armed.CompareAndSwap(true, false) {
+		c.cancel()
+		<-c.interrupted
+	}
+}
+
+func (c *interruptConn) deadline(t time.Time) {
+	if t.Equal(aLongTimeAgo) {
+		c.once.Do(func() { close(c.interrupted) })
+	}
+}
+
+func (c *interruptConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.interrupt()
+	return n, err
+}
+
+func (c *interruptConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.interrupt()
+	return n, err
+}
+
+func (c *interruptConn) SetReadDeadline(t time.Time) error {
+	err := c.Conn.SetReadDeadline(t)
+	c.deadline(t)
+	return err
+}
+
+func (c *interruptConn) SetWriteDeadline(t time.Time) error {
+	err := c.Conn.SetWriteDeadline(t)
+	c.deadline(t)
+	return err
+}
+
+func TestSendMsgContext(t *testing.T) {
+	t.Run("deadline", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer b.Close()
+
+		c := newConn(a, true, nil, nil)
+		defer c.Close()
+
+		// the peer does not read, so the write blocks until the deadline.
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		if err := c.SendMsgContext(ctx, TextMsg, "hello"); err != context.DeadlineExceeded {
+			t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+
+		// the frame may be written partially, so the connection is closed.
+		if err := c.SendMsg(TextMsg, "hello"); err == nil {
+			t.Fatal("sending after an interrupted write succeeded")
+		}
+	})
+
+	t.Run("done after the write", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer b.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ic := newInterruptConn(a, cancel)
+		c := newConn(ic, true, nil, nil)
+		defer c.Close()
+
+		frames := make(chan *Frame, 2)
+		go func() {
+			for {
+				f, err := ReadFrame(b, false)
+				if err != nil {
+					return
+				}
+				frames <- f
+			}
+		}()
+
+		ic.armed.Store(true)
+		if err := c.SendMsgContext(ctx, TextMsg, "hello"); err != nil {
+			t.Fatalf("got %v for a message which was sent", err)
+		}
+
+		// the write deadline set by ctx was cleared.
+		if err := c.SendMsg(TextMsg, "again"); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, want := range []string{"hello", "again"} {
+			if f := <-frames; string(f.Payload) != want {
+				t.Fatalf("got %q, want %q", f.Payload, want)
+			}
+		}
+	})
+}
+
+func TestRecvMsgContext(t *testing.T) {
+	t.Run("deadline", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer b.Close()
+
+		c := newConn(a, true, nil, nil)
+		defer c.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		if _, _, err := c.RecvMsgContext(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+
+		if _, _, err := c.RecvMsg(); err != context.DeadlineExceeded {
+			t.Fatalf("a later read got %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("done after the read", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer b.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ic := newInterruptConn(a, cancel)
+		c := newConn(ic, true, nil, nil)
+		defer c.Close()
+
+		// every frame is sent with a single write, and so read at once.
+		go func() {
+			WriteFrame(b, false, &Frame{Fin: true, Opcode: TextMsg, Payload: []byte("hello")})
+			WriteFrame(b, false, &Frame{Fin: true, Opcode: TextMsg, Payload: []byte("again")})
+		}()
+
+		ic.armed.Store(true)
+		if _, p, err := c.RecvMsgContext(ctx); err != nil || string(p) != "hello" {
+			t.Fatalf("got %q, %v, want the message which was read", p, err)
+		}
+
+		// the read deadline set by ctx was cleared.
+		if _, p, err := c.RecvMsg(); err != nil || string(p) != "again" {
+			t.Fatalf("got %q, %v, want again", p, err)
+		}
+	})
+}